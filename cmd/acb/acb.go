@@ -5,6 +5,7 @@ import (
 	"sort"
 
 	"github.com/mathieugilbert/cryptotax/cmd/exchange"
+	"github.com/mathieugilbert/cryptotax/cmd/reports"
 	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
@@ -19,6 +20,7 @@ type ACB struct {
 	CostBase            decimal.Decimal
 	DispositionExpenses decimal.Decimal
 	NetIncome           decimal.Decimal
+	SuperficialLoss     decimal.Decimal // denied, added to CostBase
 	CoinBalance         decimal.Decimal
 }
 
@@ -54,13 +56,16 @@ func Calculate(trades []*models.Trade, currency string) ([]*ACB, error) {
 
 	cost := make(map[string]decimal.Decimal)
 	bal := make(map[string]decimal.Decimal)
+	denied := make(map[string]decimal.Decimal)
 	oversold := make(map[string]decimal.Decimal)
 	acb := []*ACB{}
 
 	for _, t := range trades {
 		if t.Action == "buy" {
-			cost[t.Currency] = cost[t.Currency].Add(t.BaseAmount).Add(t.FeeAmount)
+			// a superficial loss goes to the units bought back
+			cost[t.Currency] = cost[t.Currency].Add(t.BaseAmount).Add(t.FeeAmount).Add(denied[t.Currency])
 			bal[t.Currency] = bal[t.Currency].Add(t.Amount)
+			delete(denied, t.Currency)
 
 			a := &ACB{
 				Asset:        t.Currency,
@@ -78,7 +83,16 @@ func Calculate(trades []*models.Trade, currency string) ([]*ACB, error) {
 				continue
 			}
 
-			cost[t.Currency] = cost[t.Currency].Div(bal[t.Currency]).Mul(nb)
+			left := cost[t.Currency].Div(bal[t.Currency]).Mul(nb)
+			gain := t.BaseAmount.Sub(cost[t.Currency].Sub(left)).Sub(t.FeeAmount)
+			sl := reports.DeniedLoss(trades, t, gain.Neg())
+			if nb.IsPositive() {
+				left = left.Add(sl)
+			} else {
+				denied[t.Currency] = denied[t.Currency].Add(sl)
+			}
+
+			cost[t.Currency] = left
 			bal[t.Currency] = nb
 
 			a := &ACB{
@@ -90,7 +104,8 @@ func Calculate(trades []*models.Trade, currency string) ([]*ACB, error) {
 				CostBase:            cost[t.Currency],
 				DispositionExpenses: t.FeeAmount,
 				CoinBalance:         bal[t.Currency],
-				NetIncome:           t.BaseAmount.Sub(cost[t.Currency]).Sub(t.FeeAmount),
+				NetIncome:           gain.Add(sl),
+				SuperficialLoss:     sl,
 			}
 			acb = append(acb, a)
 		}
@@ -4,8 +4,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/go-redis/cache"
-	"github.com/mathieugilbert/cryptotax/cmd/redis"
+	"github.com/mathieugilbert/cryptotax/cmd/exchange"
 	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
@@ -110,9 +109,9 @@ func TestCalculate(t *testing.T) {
 	if exp, act := decimal.NewFromFloat(10), c[i].DispositionExpenses; !act.Equal(exp) {
 		t.Errorf("DispositionExpenses[%v] is wrong. Got: %v, want: %v", i, act, exp)
 	}
-	//if exp, act := decimal.NewFromFloat(4990), c[i].NetIncome; !act.Equal(exp) {
-	//	t.Errorf("NetIncome[%v] is wrong. Got: %v, want: %v", i, act, exp)
-	//}
+	if exp, act := decimal.NewFromFloat(2980), c[i].NetIncome; !act.Equal(exp) {
+		t.Errorf("NetIncome[%v] is wrong. Got: %v, want: %v", i, act, exp)
+	}
 	// trade 4
 	i = 3
 	if exp, act := decimal.NewFromFloat(9035), c[i].CostBase; !act.Equal(exp) {
@@ -163,17 +162,9 @@ func TestToBaseCurrency(t *testing.T) {
 		},
 	}
 
-	// write to redis cache to prevent API call
-	codec := redis.New()
-	for _, t := range ts {
-		if t.BaseCurrency != "CAD" {
-			codec.Set(&cache.Item{
-				Key:        redis.Key(t.BaseCurrency, "CAD", t.Date),
-				Object:     "1.0",
-				Expiration: time.Hour,
-			})
-		}
-	}
+	// fixed rates to prevent API call
+	defer func(ps []exchange.RateProvider) { exchange.Providers = ps }(exchange.Providers)
+	exchange.Providers = []exchange.RateProvider{exchange.Fixed{"BTCCAD": decimal.NewFromFloat(1)}}
 	ts, err := ToBaseCurrency(ts, "CAD")
 
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"time"
 )
 
 // Configuration stores the app config
@@ -16,6 +17,13 @@ type Configuration struct {
 		Password string
 		SSLMode  string
 	}
+	Rates struct {
+		Providers    []string // in order: cryptocompare, coingecko or csv
+		Fallback     bool     // ask the next provider when one can't give a rate
+		CSVFile      string   // prices for the csv provider
+		BankOfCanada []string // Valet files of the fiat rates, .json or .csv
+		Observed     string   // window to value at the user's own fiat trades, like "2h", off when empty
+	}
 }
 
 // Read will load the config file into the Configuration object
@@ -44,3 +52,12 @@ func (c Configuration) DBString() string {
 		c.Database.SSLMode,
 	)
 }
+
+// ObservedWindow is how far from a date the user's own trades price an
+// asset, zero when off
+func (c Configuration) ObservedWindow() (time.Duration, error) {
+	if c.Rates.Observed == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(c.Rates.Observed)
+}
@@ -0,0 +1,208 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Fiat gives the rates between fiat currencies, when set
+var Fiat RateProvider
+
+// fiats are the currencies the Bank of Canada publishes rates for
+var fiats = []string{
+	"AUD", "BRL", "CAD", "CHF", "CNY", "EUR", "GBP", "HKD", "IDR", "INR",
+	"JPY", "KRW", "MXN", "MYR", "NOK", "NZD", "PEN", "RUB", "SAR", "SEK",
+	"SGD", "TRY", "TWD", "USD", "VND", "ZAR",
+}
+
+// IsFiat is true for a fiat currency
+func IsFiat(c string) bool {
+	for _, f := range fiats {
+		if f == c {
+			return true
+		}
+	}
+	return false
+}
+
+// series names are like FXUSDCAD, the CAD for one USD
+var series = regexp.MustCompile(`^FX([A-Z]{3})([A-Z]{3})$`)
+
+// businessDays is how far back a rate is looked for, past weekends and
+// holidays
+const businessDays = 10
+
+// BankOfCanada gives the daily rates published by the Bank of Canada,
+// read from files downloaded from its Valet API in JSON or CSV.
+// https://www.bankofcanada.ca/valet/observations/group/FX_RATES_DAILY/json
+type BankOfCanada struct {
+	rates map[string]decimal.Decimal
+}
+
+// NewBankOfCanada reads the Valet files, by their .json or .csv extension
+func NewBankOfCanada(fileNames ...string) (*BankOfCanada, error) {
+	p := &BankOfCanada{rates: make(map[string]decimal.Decimal)}
+	for _, f := range fileNames {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var ps []*models.Price
+		switch strings.ToLower(filepath.Ext(f)) {
+		case ".json":
+			ps, err = valetJSON(b)
+		case ".csv":
+			ps, err = valetCSV(b)
+		default:
+			err = fmt.Errorf("Bank of Canada rates should be .json or .csv: %v", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range ps {
+			p.rates[csvKey(pr.From, pr.To, pr.Date)] = pr.Rate
+		}
+	}
+	return p, nil
+}
+
+// Name of the provider
+func (p *BankOfCanada) Name() string {
+	return "Bank of Canada"
+}
+
+// Rate of from in to on the date, or the last business day before it.
+// Rates are published against CAD, other pairs are crossed through it.
+func (p *BankOfCanada) Rate(from, to string, date time.Time) (decimal.Decimal, error) {
+	for i := 0; i < businessDays; i++ {
+		d := date.AddDate(0, 0, -i)
+		f, fok := p.cad(from, d)
+		t, tok := p.cad(to, d)
+		if fok && tok {
+			return f.Div(t), nil
+		}
+	}
+	return decimal.Decimal{}, fmt.Errorf("No Bank of Canada rate for %v%v on %v", from, to, date.Format("2006-01-02"))
+}
+
+// cad is the CAD for one unit of the currency on the day
+func (p *BankOfCanada) cad(c string, date time.Time) (decimal.Decimal, bool) {
+	if c == "CAD" {
+		return decimal.NewFromFloat(1), true
+	}
+	if r, ok := p.rates[csvKey(c, "CAD", date)]; ok {
+		return r, true
+	}
+	if r, ok := p.rates[csvKey("CAD", c, date)]; ok && !r.IsZero() {
+		return decimal.NewFromFloat(1).Div(r), true
+	}
+	return decimal.Decimal{}, false
+}
+
+// valetJSON reads the observations of a Valet JSON response, like
+// {"observations":[{"d":"2018-01-02","FXUSDCAD":{"v":"1.2545"}}]}
+func valetJSON(b []byte) ([]*models.Price, error) {
+	var data struct {
+		Observations []map[string]json.RawMessage `json:"observations"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+
+	ps := []*models.Price{}
+	for _, o := range data.Observations {
+		var day string
+		if err := json.Unmarshal(o["d"], &day); err != nil {
+			return nil, fmt.Errorf("Observation without a date: %v", err)
+		}
+		for name, raw := range o {
+			if name == "d" {
+				continue
+			}
+			var v struct {
+				V string `json:"v"`
+			}
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, fmt.Errorf("Invalid %v on %v: %v", name, day, err)
+			}
+			p, err := valetPrice(day, name, v.V)
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				ps = append(ps, p)
+			}
+		}
+	}
+	return ps, nil
+}
+
+// valetCSV reads the OBSERVATIONS section of a Valet CSV response, a
+// header line of date and series names followed by a line per day
+func valetCSV(b []byte) ([]*models.Price, error) {
+	r := csv.NewReader(strings.NewReader(string(b)))
+	r.FieldsPerRecord = -1
+	lines, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	// the observations follow their section title, if there are sections
+	start := 0
+	for i, l := range lines {
+		if len(l) > 0 && l[0] == "OBSERVATIONS" {
+			start = i + 1
+			break
+		}
+	}
+	if start >= len(lines) || len(lines[start]) == 0 || lines[start][0] != "date" {
+		return nil, fmt.Errorf("No Bank of Canada observations found")
+	}
+
+	header := lines[start]
+	ps := []*models.Price{}
+	for _, l := range lines[start+1:] {
+		// a line other than a day ends the section
+		if _, err := time.Parse("2006-01-02", l[0]); err != nil {
+			break
+		}
+		for i := 1; i < len(l) && i < len(header); i++ {
+			p, err := valetPrice(l[0], header[i], l[i])
+			if err != nil {
+				return nil, err
+			}
+			if p != nil {
+				ps = append(ps, p)
+			}
+		}
+	}
+	return ps, nil
+}
+
+// valetPrice is the price of a series on the day. Series other than
+// exchange rates and days without a value have none.
+func valetPrice(day, name, value string) (*models.Price, error) {
+	m := series.FindStringSubmatch(name)
+	if m == nil || value == "" {
+		return nil, nil
+	}
+	d, err := time.Parse("2006-01-02", day)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid date: %v", day)
+	}
+	r, err := decimal.NewFromString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %v on %v: %v", name, day, value)
+	}
+	return &models.Price{From: m[1], To: m[2], Date: d, Rate: r, Source: "Bank of Canada"}, nil
+}
@@ -1,17 +1,17 @@
 // Package exchange aids in getting historical fiat prices
 // BTC/FIAT: Powered by <a href="https://www.coindesk.com/price/">CoinDesk</a>
 // ALL: https://min-api.cryptocompare.com/data/dayAvg?fsym=ETH&tsym=CAD&toTs=1489536000&extraParams="cryptotax"
+// ALL: https://api.coingecko.com/api/v3/coins/ethereum/history?date=15-03-2017
 package exchange
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"log"
 	"time"
 
 	"github.com/go-redis/cache"
 	"github.com/mathieugilbert/cryptotax/cmd/redis"
+	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
 
@@ -56,60 +56,146 @@ func (c *Conversion) SetRate() error {
 	return nil
 }
 
-// FetchRate gets the exchange rate using Cryptocompare API, if not in cache
-// Rate limits: 15/s, 300/min, 8000/hr
-func FetchRate(from, to string, date time.Time) (rate decimal.Decimal, err error) {
+// Granularity of a rate: the day's average, its close or the close of the
+// nearest hour
+type Granularity string
+
+// Granularities of rates
+const (
+	DayAverage  Granularity = "DAY_AVG"
+	DayClose    Granularity = "DAY_CLOSE"
+	NearestHour Granularity = "HOUR"
+)
+
+// ValidGranularity is true for a known granularity
+func ValidGranularity(g Granularity) bool {
+	return g == DayAverage || g == DayClose || g == NearestHour
+}
+
+// Providers are asked for rates in order
+var Providers = []RateProvider{&CryptoCompare{}}
+
+// Fallback asks the next provider when one can't give a rate
+var Fallback = true
+
+// PriceStore keeps the rates of past days for good
+type PriceStore interface {
+	GetPrice(from, to string, date time.Time) (*models.Price, error)
+	SavePrices([]*models.Price) error
+}
+
+// Store is read before asking the providers, when set
+var Store PriceStore
+
+// FetchRate gets the day's average exchange rate, through an intermediate
+// asset when there's no direct rate
+func FetchRate(from, to string, date time.Time) (decimal.Decimal, error) {
+	rate, _, err := FetchPath(from, to, date, DayAverage)
+	return rate, err
+}
+
+// fetch gets the exchange rate from the store, or the providers, if not in
+// cache, with the source it came from. Rates between fiat currencies come
+// from Fiat, the official rates are daily. The store keeps daily averages.
+func fetch(from, to string, date time.Time, g Granularity) (rate decimal.Decimal, source string, err error) {
+	// fiat pairs use the official rates
+	if Fiat != nil && IsFiat(from) && IsFiat(to) {
+		rate, err = Fiat.Rate(from, to, date)
+		return rate, Fiat.Name(), err
+	}
+
 	codec := redis.New()
-	key := redis.Key(from, to, date)
+	key := redis.Key(from, to, date, string(g))
 
 	// look for rate in cache
 	var rs string
 	if err = codec.Get(key, &rs); err == nil {
 		// found the key
 		rate, err = decimal.NewFromString(rs)
-		if err != nil {
-			return
-		}
-		return
+		return rate, "cache", err
 	}
 
-	url := fmt.Sprintf(
-		"https://min-api.cryptocompare.com/data/dayAvg?fsym=%v&tsym=%v&toTs=%v&extraParams=cryptotax",
-		from,
-		to,
-		date.Unix(),
-	)
+	// look for rate in store
+	if Store != nil && g == DayAverage {
+		if p, e := Store.GetPrice(from, to, date); e == nil {
+			cacheRate(codec, key, p.Rate)
+			return p.Rate, p.Source, nil
+		}
+	}
 
-	fmt.Printf("Calling API: %v\n", key)
+	err = fmt.Errorf("No rate providers")
+	for _, p := range Providers {
+		log.Printf("Calling %v: %v", p.Name(), key)
 
-	resp, err := http.Get(url)
+		if rate, err = providerRate(p, from, to, date, g); err == nil {
+			source = p.Name()
+			break
+		}
+		if !Fallback {
+			return
+		}
+	}
 	if err != nil {
 		return
 	}
-	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	if g == DayAverage {
+		storeRate(from, to, date, rate, source)
+	}
+	cacheRate(codec, key, rate)
+	return
+}
+
+// providerRate asks the provider for the rate at the granularity, or its
+// daily average when it has no close prices
+func providerRate(p RateProvider, from, to string, date time.Time, g Granularity) (decimal.Decimal, error) {
+	if cp, ok := p.(CloseProvider); ok && g != DayAverage {
+		return cp.Close(from, to, date, g)
+	}
+	return p.Rate(from, to, date)
+}
+
+// storeRate keeps the rate once its day is over
+func storeRate(from, to string, date time.Time, rate decimal.Decimal, source string) {
+	if Store == nil || !models.Day(date).Before(models.Day(time.Now())) {
 		return
 	}
+	if err := Store.SavePrices([]*models.Price{&models.Price{
+		From:      from,
+		To:        to,
+		Date:      date,
+		Rate:      rate,
+		Source:    source,
+		FetchedAt: time.Now(),
+	}}); err != nil {
+		log.Printf("Couldn't store %v: %v", redis.Key(from, to, date, string(DayAverage)), err)
+	}
+}
 
-	data := make(map[string]interface{})
-	err = json.Unmarshal([]byte(body), &data)
+// LoadPrices reads a price file, like the CSV provider's, into the store
+func LoadPrices(fileName, source string) (int, error) {
+	if Store == nil {
+		return 0, fmt.Errorf("No price store")
+	}
+	ps, err := readPrices(fileName)
 	if err != nil {
-		return
+		return 0, err
 	}
-	if data[to] == nil {
-		err = fmt.Errorf("Couldn't find %v", from)
-		return
+	for _, p := range ps {
+		p.Source = source
+		p.FetchedAt = time.Now()
+	}
+	if err = Store.SavePrices(ps); err != nil {
+		return 0, err
 	}
-	rate = decimal.NewFromFloat(data[to].(float64))
+	return len(ps), nil
+}
 
-	// cache the rate
+// cacheRate keeps the rate in redis for an hour
+func cacheRate(codec *cache.Codec, key string, rate decimal.Decimal) {
 	codec.Set(&cache.Item{
 		Key:        key,
 		Object:     rate.String(),
 		Expiration: time.Hour,
 	})
-
-	return
 }
@@ -1,16 +1,20 @@
 package exchange
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/mathieugilbert/cryptotax/cmd/redis"
+	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
 
 func TestConvert1(t *testing.T) {
 	// clear cache
-	redis.New().Delete("ETHBTC1489536000")
+	redis.New().Delete("ETHBTC1489536000DAY_AVG")
 
 	tcs := []struct {
 		C *Conversion
@@ -92,3 +96,331 @@ func TestInvalidSymbol(t *testing.T) {
 		t.Errorf("Wrong kind of error: %v", err.Error())
 	}
 }
+
+func TestFallback(t *testing.T) {
+	defer func(ps []RateProvider, f bool) { Providers, Fallback = ps, f }(Providers, Fallback)
+	date := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	redis.New().Delete(redis.Key("BTC", "CAD", date, string(DayAverage)))
+	redis.New().Delete(redis.Key("ETH", "CAD", date, string(DayAverage)))
+
+	Providers = []RateProvider{
+		Fixed{"BTCCAD": decimal.NewFromFloat(700)},
+		Fixed{"ETHCAD": decimal.NewFromFloat(16), "BTCCAD": decimal.NewFromFloat(1)},
+	}
+
+	// the first provider that has the rate gives it
+	Fallback = true
+	if r, err := FetchRate("BTC", "CAD", date); err != nil || !r.Equal(decimal.NewFromFloat(700)) {
+		t.Errorf("Should get 700 from the first provider. Got: %v, %v", r, err)
+	}
+	if r, err := FetchRate("ETH", "CAD", date); err != nil || !r.Equal(decimal.NewFromFloat(16)) {
+		t.Errorf("Should fall back to 16 from the second provider. Got: %v, %v", r, err)
+	}
+
+	// without fallback, only the first is asked
+	redis.New().Delete(redis.Key("ETH", "CAD", date, string(DayAverage)))
+	Fallback = false
+	if _, err := FetchRate("ETH", "CAD", date); err == nil {
+		t.Error("Should not fall back")
+	}
+}
+
+func TestFixed(t *testing.T) {
+	p := Fixed{"BTCCAD": decimal.NewFromFloat(800)}
+	date := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	if r, err := p.Rate("BTC", "CAD", date); err != nil || !r.Equal(decimal.NewFromFloat(800)) {
+		t.Errorf("Should be 800. Got: %v, %v", r, err)
+	}
+	if r, err := p.Rate("CAD", "BTC", date); err != nil || !r.Equal(decimal.NewFromFloat(0.00125)) {
+		t.Errorf("Should be the inverse, 0.00125. Got: %v, %v", r, err)
+	}
+	if _, err := p.Rate("ETH", "CAD", date); err == nil || err.Error() != "Couldn't find ETH" {
+		t.Errorf("Should not find ETH. Got: %v", err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	f, err := ioutil.TempFile("", "prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("Date,From,To,Rate\n2017-03-15,ETH,CAD,38.5\n2017-03-16,ETH,CAD,40\n")
+	f.Close()
+
+	p, err := NewCSV(f.Name())
+	if err != nil {
+		t.Fatalf("Should read the prices. Got: %v", err)
+	}
+
+	tcs := []struct {
+		from, to string
+		date     time.Time
+		rate     float64
+	}{
+		// any time in the day
+		{"ETH", "CAD", time.Date(2017, time.March, 15, 18, 30, 0, 0, time.UTC), 38.5},
+		{"ETH", "CAD", time.Date(2017, time.March, 16, 0, 0, 0, 0, time.UTC), 40},
+		// inverse
+		{"CAD", "ETH", time.Date(2017, time.March, 16, 0, 0, 0, 0, time.UTC), 0.025},
+	}
+	for _, tc := range tcs {
+		if r, err := p.Rate(tc.from, tc.to, tc.date); err != nil || !r.Equal(decimal.NewFromFloat(tc.rate)) {
+			t.Errorf("%v%v on %v should be %v. Got: %v, %v", tc.from, tc.to, tc.date, tc.rate, r, err)
+		}
+	}
+	if _, err := p.Rate("ETH", "CAD", time.Date(2017, time.March, 17, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("Should not have a rate on a missing day")
+	}
+
+	if _, err := NewProviders([]string{"csv", "coingecko", "CryptoCompare"}, f.Name()); err != nil {
+		t.Errorf("Should build the providers. Got: %v", err)
+	}
+	if _, err := NewProviders([]string{"coindesk"}, ""); err == nil {
+		t.Error("Should not build an unknown provider")
+	}
+}
+
+// memStore keeps prices in memory
+type memStore map[string]*models.Price
+
+func (m memStore) GetPrice(from, to string, date time.Time) (*models.Price, error) {
+	if p, ok := m[redis.Key(from, to, models.Day(date), "")]; ok {
+		return p, nil
+	}
+	return nil, fmt.Errorf("Not found")
+}
+
+func (m memStore) SavePrices(ps []*models.Price) error {
+	for _, p := range ps {
+		m[redis.Key(p.From, p.To, models.Day(p.Date), "")] = p
+	}
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	defer func(ps []RateProvider, s PriceStore) { Providers, Store = ps, s }(Providers, Store)
+	date := time.Date(2016, time.July, 1, 12, 0, 0, 0, time.UTC)
+	redis.New().Delete(redis.Key("BTC", "CAD", date, string(DayAverage)))
+	redis.New().Delete(redis.Key("ETH", "CAD", date, string(DayAverage)))
+
+	m := memStore{}
+	m.SavePrices([]*models.Price{&models.Price{From: "BTC", To: "CAD", Date: date, Rate: decimal.NewFromFloat(880)}})
+	Store = m
+	Providers = []RateProvider{Fixed{"BTCCAD": decimal.NewFromFloat(1), "ETHCAD": decimal.NewFromFloat(16)}}
+
+	// the stored rate is used over the providers
+	if r, err := FetchRate("BTC", "CAD", date); err != nil || !r.Equal(decimal.NewFromFloat(880)) {
+		t.Errorf("Should get 880 from the store. Got: %v, %v", r, err)
+	}
+
+	// a fetched rate is stored with its source
+	if r, err := FetchRate("ETH", "CAD", date); err != nil || !r.Equal(decimal.NewFromFloat(16)) {
+		t.Errorf("Should get 16 from the provider. Got: %v, %v", r, err)
+	}
+	if p, err := m.GetPrice("ETH", "CAD", date); err != nil || !p.Rate.Equal(decimal.NewFromFloat(16)) || p.Source != "Fixed" {
+		t.Errorf("Should store the rate fetched. Got: %+v, %v", p, err)
+	}
+
+	// a price file is loaded into the store
+	f, err := ioutil.TempFile("", "prices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("2016-07-02,LTC,CAD,5.4\n2016-07-03,LTC,CAD,5.2\n")
+	f.Close()
+
+	if n, err := LoadPrices(f.Name(), "test"); err != nil || n != 2 {
+		t.Errorf("Should load 2 prices. Got: %v, %v", n, err)
+	}
+	if p, err := m.GetPrice("LTC", "CAD", time.Date(2016, time.July, 3, 0, 0, 0, 0, time.UTC)); err != nil || !p.Rate.Equal(decimal.NewFromFloat(5.2)) || p.Source != "test" {
+		t.Errorf("Should have loaded 5.2 for July 3. Got: %+v, %v", p, err)
+	}
+}
+
+func TestBankOfCanada(t *testing.T) {
+	defer func(f RateProvider) { Fiat = f }(Fiat)
+	dir, err := ioutil.TempDir("", "boc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	js := dir + "/fx.json"
+	ioutil.WriteFile(js, []byte(`{"seriesDetail":{"FXUSDCAD":{"label":"USD/CAD"}},"observations":[
+		{"d":"2018-01-02","FXUSDCAD":{"v":"1.2545"},"FXEURCAD":{"v":"1.5066"}},
+		{"d":"2018-01-05","FXUSDCAD":{"v":"1.2407"},"FXEURCAD":{"v":"1.4951"}}
+	]}`), 0644)
+	cv := dir + "/fx.csv"
+	ioutil.WriteFile(cv, []byte(`"SERIES"
+"id","label","description"
+"FXUSDCAD","USD/CAD","US dollar to Canadian dollar daily exchange rate"
+
+"OBSERVATIONS"
+"date","FXUSDCAD"
+"2018-01-08","1.2411"
+`), 0644)
+
+	p, err := NewBankOfCanada(js, cv)
+	if err != nil {
+		t.Fatalf("Should read the rates. Got: %v", err)
+	}
+
+	tcs := []struct {
+		from, to string
+		date     time.Time
+		rate     float64
+	}{
+		{"USD", "CAD", time.Date(2018, time.January, 2, 0, 0, 0, 0, time.UTC), 1.2545},
+		// from the csv
+		{"USD", "CAD", time.Date(2018, time.January, 8, 15, 0, 0, 0, time.UTC), 1.2411},
+		// the weekend has Friday's rate
+		{"USD", "CAD", time.Date(2018, time.January, 7, 0, 0, 0, 0, time.UTC), 1.2407},
+		// inverse
+		{"CAD", "USD", time.Date(2018, time.January, 2, 0, 0, 0, 0, time.UTC), 0.797130330809087},
+		// crossed through CAD, 1.5066 / 1.2545
+		{"EUR", "USD", time.Date(2018, time.January, 2, 0, 0, 0, 0, time.UTC), 1.2009565563969709},
+	}
+	th := decimal.NewFromFloat(0.000001)
+	for _, tc := range tcs {
+		r, err := p.Rate(tc.from, tc.to, tc.date)
+		if err != nil || r.Sub(decimal.NewFromFloat(tc.rate)).Abs().GreaterThan(th) {
+			t.Errorf("%v%v on %v should be %v. Got: %v, %v", tc.from, tc.to, tc.date, tc.rate, r, err)
+		}
+	}
+	if _, err := p.Rate("USD", "CAD", time.Date(2017, time.December, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Error("Should not have a rate before the first day")
+	}
+
+	// fiat pairs are fetched from the official rates
+	Fiat = p
+	if r, err := FetchRate("USD", "CAD", time.Date(2018, time.January, 6, 0, 0, 0, 0, time.UTC)); err != nil || !r.Equal(decimal.NewFromFloat(1.2407)) {
+		t.Errorf("Should fetch the official 1.2407. Got: %v, %v", r, err)
+	}
+}
+
+func TestFetchPath(t *testing.T) {
+	defer func(ps []RateProvider, f RateProvider, s PriceStore) { Providers, Fiat, Store = ps, f, s }(Providers, Fiat, Store)
+	date := time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	m := memStore{}
+	Store = m
+	Providers = []RateProvider{Fixed{
+		"HSTBTC":  decimal.NewFromFloat(0.0001),
+		"BTCCAD":  decimal.NewFromFloat(13000),
+		"XYZUSDT": decimal.NewFromFloat(2),
+		"USDTUSD": decimal.NewFromFloat(1),
+		"CADAAA":  decimal.NewFromFloat(4),
+	}}
+	Fiat = Fixed{"USDCAD": decimal.NewFromFloat(1.25)}
+
+	tcs := []struct {
+		from, to string
+		rate     float64
+		path     string
+	}{
+		{"BTC", "CAD", 13000, "BTC>CAD"},
+		// inverse of the reverse rate
+		{"AAA", "CAD", 0.25, "AAA>CAD"},
+		{"HST", "CAD", 1.3, "HST>BTC>CAD"},
+		{"CAD", "HST", 1 / 1.3, "CAD>BTC>HST"},
+		// USD to CAD at the fiat rate
+		{"XYZ", "CAD", 2.5, "XYZ>USDT>USD>CAD"},
+	}
+	th := decimal.NewFromFloat(0.000001)
+	for _, tc := range tcs {
+		redis.New().Delete(redis.Key(tc.from, tc.to, date, string(DayAverage)))
+		r, p, err := FetchPath(tc.from, tc.to, date, DayAverage)
+		if err != nil || r.Sub(decimal.NewFromFloat(tc.rate)).Abs().GreaterThan(th) || p.String() != tc.path {
+			t.Errorf("%v%v should be %v through %v. Got: %v through %v, %v", tc.from, tc.to, tc.rate, tc.path, r, p, err)
+		}
+	}
+
+	// the path is stored with the rate
+	if p, err := m.GetPrice("HST", "CAD", date); err != nil || p.Source != "HST>BTC>CAD" {
+		t.Errorf("Should store the path used. Got: %+v, %v", p, err)
+	}
+	if _, p, err := FetchPath("HST", "CAD", date, DayAverage); err != nil || p.String() != "HST>BTC>CAD" {
+		t.Errorf("Should get the stored path. Got: %v, %v", p, err)
+	}
+
+	if _, _, err := FetchPath("QQQ", "CAD", date, DayAverage); err == nil || err.Error() != "Couldn't find QQQ" {
+		t.Errorf("Should not find QQQ. Got: %v", err)
+	}
+}
+
+// noFiat has no official rates
+type noFiat struct{}
+
+func (noFiat) Name() string {
+	return "Bank of Canada"
+}
+
+func (noFiat) Rate(from, to string, date time.Time) (decimal.Decimal, error) {
+	return decimal.Decimal{}, fmt.Errorf("No Bank of Canada rate for %v%v", from, to)
+}
+
+func TestFiatPathError(t *testing.T) {
+	defer func(ps []RateProvider, f RateProvider) { Providers, Fiat = ps, f }(Providers, Fiat)
+	date := time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC)
+	redis.New().Delete(redis.Key("EUR", "CAD", date, string(DayAverage)))
+
+	Providers = []RateProvider{Fixed{
+		"EURBTC": decimal.NewFromFloat(0.0001),
+		"BTCCAD": decimal.NewFromFloat(13000),
+		"CADEUR": decimal.NewFromFloat(0.65),
+	}}
+	Fiat = noFiat{}
+
+	// fiat pairs are never inverted or priced through crypto
+	r, p, err := FetchPath("EUR", "CAD", date, DayAverage)
+	if err == nil || err.Error() != "No Bank of Canada rate for EURCAD" || p != nil {
+		t.Errorf("Should return the Bank of Canada error. Got: %v through %v, %v", r, p, err)
+	}
+}
+
+// closes gives a different rate for each granularity
+type closes struct {
+	Fixed
+}
+
+func (p closes) Close(from, to string, date time.Time, g Granularity) (decimal.Decimal, error) {
+	r, err := p.Rate(from, to, date)
+	if g == DayClose {
+		return r.Add(decimal.NewFromFloat(100)), err
+	}
+	return r.Add(decimal.NewFromFloat(10)), err
+}
+
+func TestGranularity(t *testing.T) {
+	defer func(ps []RateProvider) { Providers = ps }(Providers)
+	date := time.Date(2018, time.February, 6, 14, 40, 0, 0, time.UTC)
+
+	tcs := []struct {
+		p    RateProvider
+		g    Granularity
+		rate float64
+	}{
+		{closes{Fixed{"BTCCAD": decimal.NewFromFloat(8000)}}, DayAverage, 8000},
+		{closes{Fixed{"BTCCAD": decimal.NewFromFloat(8000)}}, DayClose, 8100},
+		{closes{Fixed{"BTCCAD": decimal.NewFromFloat(8000)}}, NearestHour, 8010},
+		// the daily average without close prices
+		{Fixed{"BTCCAD": decimal.NewFromFloat(8000)}, NearestHour, 8000},
+	}
+	for _, tc := range tcs {
+		redis.New().Delete(redis.Key("BTC", "CAD", date, string(tc.g)))
+		Providers = []RateProvider{tc.p}
+		if r, _, err := FetchPath("BTC", "CAD", date, tc.g); err != nil || !r.Equal(decimal.NewFromFloat(tc.rate)) {
+			t.Errorf("%v should be %v. Got: %v, %v", tc.g, tc.rate, r, err)
+		}
+	}
+
+	if redis.Key("BTC", "CAD", date, string(DayAverage)) == redis.Key("BTC", "CAD", date, string(NearestHour)) {
+		t.Error("Granularities should be cached separately")
+	}
+	if ValidGranularity("MINUTE") {
+		t.Error("Should not be a valid granularity")
+	}
+}
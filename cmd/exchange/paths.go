@@ -0,0 +1,101 @@
+package exchange
+
+import (
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Intermediates are the assets a rate is priced through, in order, when
+// there's no direct rate. Small tokens mostly trade against BTC or USDT.
+var Intermediates = [][]string{
+	{"BTC"},
+	{"ETH"},
+	{"USDT", "USD"},
+}
+
+// Path is the currencies a rate was priced through, from first to last
+type Path []string
+
+func (p Path) String() string {
+	return strings.Join(p, ">")
+}
+
+// FetchPath gets the exchange rate at the granularity directly or, failing
+// that, through the intermediates, with the rates of each step on the same
+// date. Returns the path used. Daily averages found through a path are
+// stored with it as their source. Fiat pairs only have the official rates.
+func FetchPath(from, to string, date time.Time, g Granularity) (decimal.Decimal, Path, error) {
+	rate, source, err := fetch(from, to, date, g)
+	if err == nil {
+		return rate, pathOf(from, to, source), nil
+	}
+
+	// a rate through crypto assets would not be the official one
+	if Fiat != nil && IsFiat(from) && IsFiat(to) {
+		return rate, nil, err
+	}
+
+	// the inverse of the reverse rate
+	if inv, source, e := fetch(to, from, date, g); e == nil && !inv.IsZero() {
+		p := pathOf(to, from, source)
+		for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+			p[i], p[j] = p[j], p[i]
+		}
+		return decimal.NewFromFloat(1).Div(inv), p, nil
+	}
+
+	for _, via := range Intermediates {
+		if includes(via, from) || includes(via, to) {
+			continue
+		}
+		p := append(append(Path{from}, via...), to)
+		r, e := p.rate(date, g)
+		if e != nil {
+			continue
+		}
+		if g == DayAverage {
+			storeRate(from, to, date, r, p.String())
+		}
+		return r, p, nil
+	}
+	// the direct rate's error is the one returned
+	return rate, nil, err
+}
+
+// pathOf a rate from its source, stored rates may have been found
+// through a path
+func pathOf(from, to, source string) Path {
+	if strings.Contains(source, ">") {
+		return Path(strings.Split(source, ">"))
+	}
+	return Path{from, to}
+}
+
+// rate multiplies the rates of each step of the path. A step without a
+// rate uses the inverse of the reverse step.
+func (p Path) rate(date time.Time, g Granularity) (decimal.Decimal, error) {
+	rate := decimal.NewFromFloat(1)
+	for i := 0; i+1 < len(p); i++ {
+		r, _, err := fetch(p[i], p[i+1], date, g)
+		if err != nil {
+			inv, _, e := fetch(p[i+1], p[i], date, g)
+			if e != nil || inv.IsZero() {
+				return decimal.Decimal{}, err
+			}
+			r = decimal.NewFromFloat(1).Div(inv)
+		}
+		rate = rate.Mul(r)
+	}
+	return rate, nil
+}
+
+func includes(cs []string, c string) bool {
+	for _, x := range cs {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
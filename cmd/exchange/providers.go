@@ -0,0 +1,318 @@
+package exchange
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// RateProvider gets the historical rate of a currency in another
+type RateProvider interface {
+	Name() string
+	Rate(from, to string, date time.Time) (decimal.Decimal, error)
+}
+
+// CloseProvider also gives the close price of a day or an hour
+type CloseProvider interface {
+	Close(from, to string, date time.Time, g Granularity) (decimal.Decimal, error)
+}
+
+// NewProviders builds the providers named, in order. The CSV provider reads
+// its prices from csvFile.
+func NewProviders(names []string, csvFile string) ([]RateProvider, error) {
+	if len(names) == 0 {
+		return []RateProvider{&CryptoCompare{}}, nil
+	}
+
+	ps := []RateProvider{}
+	for _, n := range names {
+		switch strings.ToLower(n) {
+		case "cryptocompare":
+			ps = append(ps, &CryptoCompare{})
+		case "coingecko":
+			ps = append(ps, &CoinGecko{})
+		case "csv":
+			p, err := NewCSV(csvFile)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, p)
+		default:
+			return nil, fmt.Errorf("Unknown rate provider %v", n)
+		}
+	}
+	return ps, nil
+}
+
+// CryptoCompare gets the daily average rate
+// Rate limits: 15/s, 300/min, 8000/hr
+type CryptoCompare struct{}
+
+// Name of the provider
+func (p *CryptoCompare) Name() string {
+	return "CryptoCompare"
+}
+
+// Rate of from in to on the date
+func (p *CryptoCompare) Rate(from, to string, date time.Time) (rate decimal.Decimal, err error) {
+	url := fmt.Sprintf(
+		"https://min-api.cryptocompare.com/data/dayAvg?fsym=%v&tsym=%v&toTs=%v&extraParams=cryptotax",
+		from,
+		to,
+		date.Unix(),
+	)
+
+	data := make(map[string]interface{})
+	if err = getJSON(url, &data); err != nil {
+		return
+	}
+	r, ok := data[to].(float64)
+	if !ok {
+		err = fmt.Errorf("Couldn't find %v", from)
+		return
+	}
+	rate = decimal.NewFromFloat(r)
+	return
+}
+
+// Close of the day, or of the hour nearest the date
+func (p *CryptoCompare) Close(from, to string, date time.Time, g Granularity) (decimal.Decimal, error) {
+	switch g {
+	case DayClose:
+		day := models.Day(date)
+		return p.histo("histoday", from, to, date, day)
+	case NearestHour:
+		// the candle closing at the nearest hour
+		hour := date.Round(time.Hour)
+		return p.histo("histohour", from, to, hour, hour.Add(-time.Hour))
+	}
+	return p.Rate(from, to, date)
+}
+
+// histo gets the close of the candle starting at open, from those up to toTs
+func (p *CryptoCompare) histo(endpoint, from, to string, toTs, open time.Time) (rate decimal.Decimal, err error) {
+	url := fmt.Sprintf(
+		"https://min-api.cryptocompare.com/data/%v?fsym=%v&tsym=%v&limit=1&toTs=%v&extraParams=cryptotax",
+		endpoint,
+		from,
+		to,
+		toTs.Unix(),
+	)
+
+	var data struct {
+		Response string
+		Data     []struct {
+			Time  int64   `json:"time"`
+			Close float64 `json:"close"`
+		}
+	}
+	if err = getJSON(url, &data); err != nil {
+		return
+	}
+	for _, d := range data.Data {
+		if d.Time == open.Unix() && d.Close != 0 {
+			rate = decimal.NewFromFloat(d.Close)
+			return
+		}
+	}
+	err = fmt.Errorf("Couldn't find %v", from)
+	return
+}
+
+// CoinGecko gets the daily price of a coin. Coins are looked up by their
+// CoinGecko id, the symbols of the most common ones are mapped.
+// Rate limits: 100/min
+type CoinGecko struct {
+	IDs map[string]string // symbol to coin id, added to the common ones
+}
+
+// coinGeckoIDs maps the common symbols to their CoinGecko coin id
+var coinGeckoIDs = map[string]string{
+	"BTC":  "bitcoin",
+	"ETH":  "ethereum",
+	"LTC":  "litecoin",
+	"BCH":  "bitcoin-cash",
+	"XRP":  "ripple",
+	"ETC":  "ethereum-classic",
+	"XLM":  "stellar",
+	"ADA":  "cardano",
+	"DASH": "dash",
+	"XMR":  "monero",
+	"ZEC":  "zcash",
+	"DOGE": "dogecoin",
+	"EOS":  "eos",
+	"NEO":  "neo",
+	"USDT": "tether",
+}
+
+// Name of the provider
+func (p *CoinGecko) Name() string {
+	return "CoinGecko"
+}
+
+// Rate of from in to on the date. Prices are only quoted for coins, so a
+// rate from a fiat currency is the inverse of the coin's price.
+func (p *CoinGecko) Rate(from, to string, date time.Time) (decimal.Decimal, error) {
+	if id := p.id(from); id != "" {
+		return p.price(id, to, date)
+	}
+	if id := p.id(to); id != "" {
+		price, err := p.price(id, from, date)
+		if err != nil {
+			return price, err
+		}
+		return decimal.NewFromFloat(1).Div(price), nil
+	}
+	return decimal.Decimal{}, fmt.Errorf("Couldn't find %v", from)
+}
+
+func (p *CoinGecko) id(symbol string) string {
+	if id, ok := p.IDs[symbol]; ok {
+		return id
+	}
+	return coinGeckoIDs[symbol]
+}
+
+// price of the coin in the currency on the date
+func (p *CoinGecko) price(id, currency string, date time.Time) (price decimal.Decimal, err error) {
+	url := fmt.Sprintf(
+		"https://api.coingecko.com/api/v3/coins/%v/history?date=%v&localization=false",
+		id,
+		date.UTC().Format("02-01-2006"),
+	)
+
+	var data struct {
+		MarketData struct {
+			CurrentPrice map[string]float64 `json:"current_price"`
+		} `json:"market_data"`
+	}
+	if err = getJSON(url, &data); err != nil {
+		return
+	}
+	pr, ok := data.MarketData.CurrentPrice[strings.ToLower(currency)]
+	if !ok || pr == 0 {
+		err = fmt.Errorf("Couldn't find %v in %v", id, currency)
+		return
+	}
+	price = decimal.NewFromFloat(pr)
+	return
+}
+
+// CSV gets rates from a local price file, one rate per line as
+// date (2006-01-02), from, to, rate
+type CSV struct {
+	rates map[string]decimal.Decimal
+}
+
+// NewCSV reads the price file
+func NewCSV(fileName string) (*CSV, error) {
+	ps, err := readPrices(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &CSV{rates: make(map[string]decimal.Decimal)}
+	for _, pr := range ps {
+		p.rates[csvKey(pr.From, pr.To, pr.Date)] = pr.Rate
+	}
+	return p, nil
+}
+
+// readPrices reads a price file with an optional header
+func readPrices(fileName string) ([]*models.Price, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := []*models.Price{}
+	for i, l := range lines {
+		if len(l) != 4 {
+			return nil, fmt.Errorf("Line %v should have a date, from, to and rate", i+1)
+		}
+		// skip the header
+		if i == 0 && strings.ToLower(l[0]) == "date" {
+			continue
+		}
+		d, err := time.Parse("2006-01-02", l[0])
+		if err != nil {
+			return nil, fmt.Errorf("Line %v has an invalid date: %v", i+1, l[0])
+		}
+		r, err := decimal.NewFromString(l[3])
+		if err != nil {
+			return nil, fmt.Errorf("Line %v has an invalid rate: %v", i+1, l[3])
+		}
+		ps = append(ps, &models.Price{From: l[1], To: l[2], Date: d, Rate: r})
+	}
+	return ps, nil
+}
+
+// Name of the provider
+func (p *CSV) Name() string {
+	return "CSV"
+}
+
+// Rate of from in to on the day of the date, or the inverse of the rate
+// of to in from
+func (p *CSV) Rate(from, to string, date time.Time) (decimal.Decimal, error) {
+	if r, ok := p.rates[csvKey(from, to, date)]; ok {
+		return r, nil
+	}
+	if r, ok := p.rates[csvKey(to, from, date)]; ok && !r.IsZero() {
+		return decimal.NewFromFloat(1).Div(r), nil
+	}
+	return decimal.Decimal{}, fmt.Errorf("Couldn't find %v", from)
+}
+
+func csvKey(from, to string, date time.Time) string {
+	return fmt.Sprintf("%v%v%v", from, to, date.UTC().Format("2006-01-02"))
+}
+
+// Fixed gives the same rates on any date, keyed by from and to
+// currencies like "BTCCAD". It lets tests run without the network.
+type Fixed map[string]decimal.Decimal
+
+// Name of the provider
+func (p Fixed) Name() string {
+	return "Fixed"
+}
+
+// Rate of from in to, or the inverse of the rate of to in from
+func (p Fixed) Rate(from, to string, date time.Time) (decimal.Decimal, error) {
+	if r, ok := p[from+to]; ok {
+		return r, nil
+	}
+	if r, ok := p[to+from]; ok && !r.IsZero() {
+		return decimal.NewFromFloat(1).Div(r), nil
+	}
+	return decimal.Decimal{}, fmt.Errorf("Couldn't find %v", from)
+}
+
+// getJSON calls the url and unmarshals its response body into v
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,176 @@
+package parsers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Binance struct
+type Binance struct{}
+
+// regexp match fields
+var binanceHeader = []string{"Date\\(UTC\\)", "Pair", "Side", "Price", "Executed", "Amount", "Fee"}
+
+// binanceQuotes are the assets Binance lists markets against.
+// Checked in order, so a symbol must come before any shorter one it ends with.
+var binanceQuotes = []string{
+	"USDT", "BUSD", "USDC", "TUSD", "USDS", "FDUSD",
+	"BTC", "ETH", "BNB", "XRP", "TRX", "PAX", "DAI",
+	"EUR", "GBP", "AUD", "TRY", "RUB", "BRL", "NGN", "UAH", "BIDR", "IDRT",
+}
+
+// Parse a Binance Trade History file
+/*
+Date(UTC): date
+Pair: trading pair, no separator (ETHBTC)
+Side: action
+Price: unit base price
+Executed: amount, suffixed with currency (0.5ETH)
+Amount: base amount, suffixed with base currency (0.01BTC)
+Fee: fee amount, suffixed with fee currency (0.0005BNB)
+*/
+func (Binance) Parse(r *csv.Reader) (*Result, error) {
+	res := &Result{}
+	onData := false
+
+	// rows before the header may be of any length
+	r.FieldsPerRecord = -1
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
+
+		// skip header rows
+		if !onData {
+			if valuesContain(row, binanceHeader) {
+				onData = true
+			}
+			continue
+		}
+
+		if len(row) < len(binanceHeader) {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), len(binanceHeader)))
+			continue
+		}
+
+		action := strings.ToUpper(row[2])
+		// skip if not a buy or sell
+		if !ValidAction(action) {
+			res.skip(line, fmt.Sprintf("not a buy or sell: %v", row[2]))
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02 15:04:05", row[0])
+		if err != nil {
+			res.fail(line, "Date(UTC)", row[0], "invalid date")
+		}
+
+		currency, baseCur, err := splitPair(strings.ToUpper(row[1]), binanceQuotes)
+		if err != nil {
+			res.fail(line, "Pair", row[1], "unknown trading pair")
+		}
+
+		amount, cur, err := splitAmount(row[4], currency)
+		if err != nil {
+			res.fail(line, "Executed", row[4], "invalid amount")
+		}
+		if cur != "" {
+			currency = cur
+		}
+
+		baseAmt, cur, err := splitAmount(row[5], baseCur)
+		if err != nil {
+			res.fail(line, "Amount", row[5], "invalid amount")
+		}
+		if cur != "" {
+			baseCur = cur
+		}
+
+		feeAmt, feeCur, err := splitAmount(row[6], currency, baseCur)
+		if err != nil {
+			res.fail(line, "Fee", row[6], "invalid amount")
+		}
+		if feeCur == "" {
+			// fee charged in what was received
+			if action == "BUY" {
+				feeCur = currency
+			} else {
+				feeCur = baseCur
+			}
+		}
+
+		if res.failedOn(line) {
+			continue
+		}
+
+		res.Trades = append(res.Trades, Trade{
+			Date:         date,
+			Action:       action,
+			Amount:       amount,
+			Currency:     html.EscapeString(currency),
+			BaseAmount:   baseAmt,
+			BaseCurrency: html.EscapeString(baseCur),
+			FeeAmount:    feeAmt,
+			FeeCurrency:  html.EscapeString(feeCur),
+		})
+	}
+	return res, nil
+}
+
+// Headers of a Binance file
+func (Binance) Headers() [][]string {
+	return [][]string{binanceHeader}
+}
+
+// splitPair separates a pair with no separator, ie: "ETHBTC" into "ETH" and "BTC"
+func splitPair(pair string, quotes []string) (string, string, error) {
+	for _, q := range quotes {
+		if len(pair) > len(q) && strings.HasSuffix(pair, q) {
+			return pair[:len(pair)-len(q)], q, nil
+		}
+	}
+	return "", "", fmt.Errorf("Couldn't determine pair: %v", pair)
+}
+
+// match: "1,234.5ETH", "0.001", "12 BNB"
+var amountRE = regexp.MustCompile("^([0-9.,]+)\\s*([A-Za-z0-9]*)$")
+
+// splitAmount separates an amount from its currency suffix, if any. The
+// known currencies are matched first, symbols like 1INCH start with a digit.
+func splitAmount(s string, known ...string) (decimal.Decimal, string, error) {
+	s = strings.TrimSpace(s)
+	for _, k := range known {
+		if k == "" || len(s) <= len(k) || !strings.EqualFold(s[len(s)-len(k):], k) {
+			continue
+		}
+		n := strings.Replace(strings.TrimSpace(s[:len(s)-len(k)]), ",", "", -1)
+		if d, err := decimal.NewFromString(n); err == nil {
+			return d, strings.ToUpper(k), nil
+		}
+	}
+
+	m := amountRE.FindStringSubmatch(s)
+	if m == nil {
+		return decimal.Decimal{}, "", fmt.Errorf("decimal.NewFromString failed: %v", s)
+	}
+
+	d, err := decimal.NewFromString(strings.Replace(m[1], ",", "", -1))
+	if err != nil {
+		return decimal.Decimal{}, "", fmt.Errorf("decimal.NewFromString failed: %v", s)
+	}
+
+	return d, strings.ToUpper(m[2]), nil
+}
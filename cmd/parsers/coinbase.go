@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"html"
 	"io"
-	"log"
 	"regexp"
 	"strings"
 	"time"
@@ -16,82 +15,251 @@ import (
 // Coinbase struct
 type Coinbase struct{}
 
+// coinbaseLayout holds the column positions of one version of the export
+type coinbaseLayout struct {
+	header   []string
+	date     int
+	action   int
+	asset    int
+	amount   int
+	spot     int
+	currency int // column holding the spot price currency, -1 if in header
+	subtotal int // -1 if not provided
+	total    int
+	fees     int // -1 if not provided
+	notes    int
+	// base currency is only written in the notes of buys and sells
+	notesCurrency bool
+}
+
+var coinbaseLayouts = []*coinbaseLayout{
+	// 2018: fees have to be derived from the total
+	{
+		header:   []string{"Timestamp", "Transaction Type", "Asset", "Quantity Transacted", ".+ Spot Price at Transaction", ".+ Amount Transacted \\(Inclusive of Coinbase Fees\\)", "Address", "Notes"},
+		date:     0,
+		action:   1,
+		asset:    2,
+		amount:   3,
+		spot:     4,
+		currency: -1,
+		subtotal: -1,
+		total:    5,
+		fees:     -1,
+		notes:    7,
+
+		notesCurrency: true,
+	},
+	// 2021: currency is in the header
+	{
+		header:   []string{"Timestamp", "Transaction Type", "Asset", "Quantity Transacted", ".+ Spot Price at Transaction", ".+ Subtotal", ".+ Total \\(inclusive of fees\\)", ".+ Fees", "Notes"},
+		date:     0,
+		action:   1,
+		asset:    2,
+		amount:   3,
+		spot:     4,
+		currency: -1,
+		subtotal: 5,
+		total:    6,
+		fees:     7,
+		notes:    8,
+	},
+	// 2022: currency has its own column
+	{
+		header:   []string{"Timestamp", "Transaction Type", "Asset", "Quantity Transacted", "Spot Price Currency", "Spot Price at Transaction", "Subtotal", "Total \\(inclusive of fees.*\\)", "Fees.*", "Notes"},
+		date:     0,
+		action:   1,
+		asset:    2,
+		amount:   3,
+		spot:     5,
+		currency: 4,
+		subtotal: 6,
+		total:    7,
+		fees:     8,
+		notes:    9,
+	},
+}
+
+// coinbaseActions maps Coinbase transaction types to trade and event actions
+var coinbaseActions = map[string]string{
+	"BUY":                 "BUY",
+	"ADVANCED TRADE BUY":  "BUY",
+	"SELL":                "SELL",
+	"ADVANCED TRADE SELL": "SELL",
+	"CONVERT":             "CONVERT",
+	"SEND":                "SEND",
+	"RECEIVE":             "RECEIVE",
+	"REWARDS INCOME":      "REWARD",
+	"COINBASE EARN":       "REWARD",
+	"LEARNING REWARD":     "REWARD",
+	"INFLATION REWARD":    "STAKING",
+	"STAKING INCOME":      "STAKING",
+	"INTEREST INCOME":     "INTEREST",
+	"AIRDROP":             "AIRDROP",
+}
+
 // Parse a Coinbase file
 /*
-Timestamp: date
+Timestamp: date, with time of day in current exports
 Transaction Type: action
 Asset: currency
 Quantity Transacted: amount
+Spot Price Currency: base currency, otherwise in the header
 CAD Spot Price at Transaction: unit price
-CAD Amount Transacted (Inclusive of Coinbase Fees): baseAmt + feeAmt
-Address: withdrawal/deposit address
+CAD Subtotal: baseAmt
+CAD Total (inclusive of fees): baseAmt + feeAmt
+CAD Fees: feeAmt
+Address: withdrawal/deposit address, 2018 only
 Notes: description
+
+Convert rows become a sell against the converted-to asset, which
+reports expand into a linked sell/buy pair.
+Send, Receive and reward rows become non-trade events.
 */
-func (Coinbase) Parse(r *csv.Reader) (trades []Trade, parseError error) {
-	// regexp match fields
-	header := []string{"Timestamp", "Transaction Type", "Asset", "Quantity Transacted", ".+ Spot Price at Transaction", ".+ Amount Transacted \\(Inclusive of Coinbase Fees\\)", "Address", "Notes"}
-	onData := false
+func (Coinbase) Parse(r *csv.Reader) (*Result, error) {
+	res := &Result{}
+	var layout *coinbaseLayout
+	var header []string
+	var headerCur string
+
+	// rows are of varying length before the header
+	r.FieldsPerRecord = -1
 
-	for i := 0; ; i++ {
+	for {
 		row, err := r.Read()
 		if err == io.EOF {
-			log.Printf("eof. line: %v\n", i)
 			break
 		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
 
 		// skip header rows
-		if !onData {
-			if valuesContain(row, header) {
-				onData = true
+		if layout == nil {
+			for _, l := range coinbaseLayouts {
+				if !valuesContain(row, l.header) {
+					continue
+				}
+				layout = l
+				header = row
+				if l.currency == -1 {
+					// "CAD Spot Price at Transaction"
+					headerCur = strings.ToUpper(strings.Fields(row[l.spot])[0])
+				}
+				break
 			}
 			continue
 		}
 
-		var date time.Time
-		if date, err = time.Parse("01/02/2006", row[0]); err != nil {
-			parseError = fmt.Errorf("time.Parse failed: %v", row[0])
-			break
+		if len(row) != len(layout.header) {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), len(layout.header)))
+			continue
+		}
+
+		// fail records the value of a column that couldn't be read
+		fail := func(col int, reason string) {
+			res.fail(line, header[col], row[col], reason)
 		}
 
-		action := strings.ToUpper(row[1])
-		// skip if not a buy or sell
-		if !ValidAction(action) {
+		action, ok := coinbaseActions[strings.ToUpper(row[layout.action])]
+		// skip if not a trade or event
+		if !ok {
+			res.skip(line, fmt.Sprintf("unsupported transaction type: %v", row[layout.action]))
 			continue
 		}
 
-		currency := strings.ToUpper(html.EscapeString(row[2]))
+		date, err := parseTime(row[layout.date], time.RFC3339, "2006-01-02 15:04:05 MST", "01/02/2006")
+		if err != nil {
+			fail(layout.date, "invalid date")
+		}
 
-		var amount decimal.Decimal
-		if amount, err = decimal.NewFromString(row[3]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[3])
-			break
+		currency := strings.ToUpper(html.EscapeString(row[layout.asset]))
+
+		amount, err := decimal.NewFromString(row[layout.amount])
+		if err != nil {
+			fail(layout.amount, "invalid number")
 		}
 
-		var unitPrice decimal.Decimal
-		if unitPrice, err = decimal.NewFromString(row[4]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[4])
-			break
+		unitPrice, err := decimal.NewFromString(cleanMoney(row[layout.spot]))
+		if err != nil {
+			fail(layout.spot, "invalid number")
 		}
 
-		var totalCost decimal.Decimal
-		if totalCost, err = decimal.NewFromString(row[5]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[5])
-			break
+		totalCost, err := decimal.NewFromString(cleanMoney(row[layout.total]))
+		if err != nil {
+			fail(layout.total, "invalid number")
 		}
 
 		baseAmt := amount.Mul(unitPrice)
-		feeAmt := totalCost.Sub(baseAmt)
+		if layout.subtotal > -1 {
+			if baseAmt, err = decimal.NewFromString(cleanMoney(row[layout.subtotal])); err != nil {
+				fail(layout.subtotal, "invalid number")
+			}
+		}
 
-		// base currency is hidden in
-		re := regexp.MustCompile("for\\s.+\\s(\\w+)")
-		m := re.FindStringSubmatch(row[7])
-		if m == nil {
-			parseError = fmt.Errorf("Couldn't determine currency")
-			break
+		// without a fees column, the fee of a trade is what its total adds;
+		// transfers and rewards have no total
+		var feeAmt decimal.Decimal
+		trade := ValidAction(action) || action == "CONVERT"
+		if trade && strings.TrimSpace(row[layout.total]) != "" {
+			feeAmt = totalCost.Sub(baseAmt).Abs()
 		}
-		baseCur := strings.ToUpper(m[1])
+		if layout.fees > -1 {
+			if feeAmt, err = decimal.NewFromString(cleanMoney(row[layout.fees])); err != nil {
+				fail(layout.fees, "invalid number")
+			}
+		}
+
+		baseCur := headerCur
+		if layout.currency > -1 {
+			baseCur = strings.ToUpper(html.EscapeString(row[layout.currency]))
+		}
+		if layout.notesCurrency && ValidAction(action) {
+			// base currency is hidden in the notes
+			re := regexp.MustCompile("for\\s.+\\s(\\w+)")
+			if m := re.FindStringSubmatch(row[layout.notes]); m != nil {
+				baseCur = strings.ToUpper(m[1])
+			} else {
+				fail(layout.notes, "currency not found")
+			}
+		}
+
+		if action == "CONVERT" {
+			// received asset and amount are only in the notes
+			re := regexp.MustCompile("Converted\\s+[0-9.,]+\\s+\\w+\\s+to\\s+([0-9.,]+)\\s+(\\w+)")
+			m := re.FindStringSubmatch(row[layout.notes])
+			if m == nil {
+				fail(layout.notes, "converted asset not found")
+				continue
+			}
+
+			toAmt, err := decimal.NewFromString(strings.Replace(m[1], ",", "", -1))
+			if err != nil {
+				fail(layout.notes, "invalid converted amount")
+			}
 
-		trades = append(trades, Trade{
+			if res.failedOn(line) {
+				continue
+			}
+
+			res.Trades = append(res.Trades, Trade{
+				Date:         date,
+				Action:       "SELL",
+				Amount:       amount,
+				Currency:     currency,
+				BaseAmount:   toAmt,
+				BaseCurrency: strings.ToUpper(html.EscapeString(m[2])),
+				FeeAmount:    feeAmt,
+				FeeCurrency:  baseCur,
+			})
+			continue
+		}
+
+		if res.failedOn(line) {
+			continue
+		}
+
+		res.Trades = append(res.Trades, Trade{
 			Date:         date,
 			Action:       action,
 			Amount:       amount,
@@ -102,5 +270,25 @@ func (Coinbase) Parse(r *csv.Reader) (trades []Trade, parseError error) {
 			FeeCurrency:  baseCur,
 		})
 	}
-	return
+	return res, nil
+}
+
+// Headers of a Coinbase file, one per export version
+func (Coinbase) Headers() [][]string {
+	var hs [][]string
+	for _, l := range coinbaseLayouts {
+		hs = append(hs, l.header)
+	}
+	return hs
+}
+
+// cleanMoney strips currency symbols and separators: "$1,000.00" -> "1000.00"
+func cleanMoney(s string) string {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "$")
+	s = strings.Replace(s, ",", "", -1)
+	if s == "" {
+		return "0"
+	}
+	return s
 }
@@ -16,11 +16,12 @@ import (
 // Custom trade struct
 type Custom struct{}
 
+// regexp match fields
+var customHeader = []string{"date", "action", "amount", "currency", "base_amount", "base_currency", "fee_amount", "fee_currency"}
+
 // Generate a CSV file from the custom entered trades
 func (Custom) Generate(ts []*models.Trade) ([]byte, error) {
-	records := [][]string{
-		{"date", "action", "amount", "currency", "base_amount", "base_currency", "fee_amount", "fee_currency"},
-	}
+	records := [][]string{customHeader}
 
 	for _, t := range ts {
 		records = append(records, []string{
@@ -47,62 +48,74 @@ func (Custom) Generate(ts []*models.Trade) ([]byte, error) {
 }
 
 // Parse a custom trade file
-func (Custom) Parse(r *csv.Reader) (trades []Trade, parseError error) {
-	// regexp match fields
-	header := []string{"date", "action", "amount", "currency", "base_amount", "base_currency", "fee_amount", "fee_currency"}
+func (Custom) Parse(r *csv.Reader) (*Result, error) {
+	res := &Result{}
 	onData := false
 
-	for i := 0; ; i++ {
+	// rows before the header may be of any length
+	r.FieldsPerRecord = -1
+
+	for {
 		row, err := r.Read()
 		if err == io.EOF {
 			break
 		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
 
 		// skip header rows
 		if !onData {
-			if valuesContain(row, header) {
+			if valuesContain(row, customHeader) {
 				onData = true
 			}
 			continue
 		}
 
-		var date time.Time
-		if date, err = time.Parse("2006-01-02", row[0]); err != nil {
-			parseError = fmt.Errorf("time.Parse failed: %v", row[0])
-			break
+		if len(row) < len(customHeader) {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), len(customHeader)))
+			continue
 		}
 
 		action := strings.ToUpper(row[1])
-		// skip if not a buy or sell
-		if !ValidAction(action) {
+		// skip if not a buy, sell or income
+		if !ValidAction(action) && !models.IsIncome(action) {
+			res.skip(line, fmt.Sprintf("not a buy, sell or income: %v", row[1]))
 			continue
 		}
 
-		var amount decimal.Decimal
-		if amount, err = decimal.NewFromString(row[2]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[2])
-			break
+		date, err := time.Parse("2006-01-02", row[0])
+		if err != nil {
+			res.fail(line, "date", row[0], "invalid date")
+		}
+
+		amount, err := decimal.NewFromString(row[2])
+		if err != nil {
+			res.fail(line, "amount", row[2], "invalid number")
 		}
 
 		currency := strings.ToUpper(html.EscapeString(row[3]))
 
-		var baseAmt decimal.Decimal
-		if baseAmt, err = decimal.NewFromString(row[4]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[4])
-			break
+		baseAmt, err := decimal.NewFromString(row[4])
+		if err != nil {
+			res.fail(line, "base_amount", row[4], "invalid number")
 		}
 
 		baseCur := strings.ToUpper(html.EscapeString(row[5]))
 
-		var feeAmt decimal.Decimal
-		if feeAmt, err = decimal.NewFromString(row[6]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[6])
-			break
+		feeAmt, err := decimal.NewFromString(row[6])
+		if err != nil {
+			res.fail(line, "fee_amount", row[6], "invalid number")
 		}
 
 		feeCur := strings.ToUpper(html.EscapeString(row[7]))
 
-		trades = append(trades, Trade{
+		if res.failedOn(line) {
+			continue
+		}
+
+		res.Trades = append(res.Trades, Trade{
 			Date:         date,
 			Action:       action,
 			Amount:       amount,
@@ -113,5 +126,10 @@ func (Custom) Parse(r *csv.Reader) (trades []Trade, parseError error) {
 			FeeCurrency:  feeCur,
 		})
 	}
-	return
+	return res, nil
+}
+
+// Headers of a custom trade file
+func (Custom) Headers() [][]string {
+	return [][]string{customHeader}
 }
@@ -0,0 +1,105 @@
+package parsers
+
+import (
+	"encoding/csv"
+	"io"
+	"regexp"
+	"sort"
+)
+
+// Signed parsers know the header rows of the files they read
+type Signed interface {
+	Headers() [][]string
+}
+
+// Candidate is an exchange whose format may match a file
+type Candidate struct {
+	Exchange string  `json:"exchange"`
+	Score    float64 `json:"score"`
+}
+
+// rows read looking for a header, exports have a few lines of preamble at most
+const detectRows = 25
+
+// candidates below this score are not worth suggesting
+const minScore = 0.5
+
+// Detect compares the first rows of a file with the header signature of
+// each named exchange's parser. The exchange is returned when exactly one
+// matches fully, otherwise the best candidates are returned ranked.
+func Detect(r *csv.Reader, exchanges []string) (string, []*Candidate) {
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	var rows [][]string
+	for i := 0; i < detectRows; i++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		rows = append(rows, row)
+	}
+
+	var cs []*Candidate
+	for _, e := range exchanges {
+		p, err := NewParser(e)
+		if err != nil {
+			continue
+		}
+		s, ok := p.(Signed)
+		if !ok {
+			continue
+		}
+
+		best := 0.0
+		for _, h := range s.Headers() {
+			for _, row := range rows {
+				if score := headerScore(row, h); score > best {
+					best = score
+				}
+			}
+		}
+		if best >= minScore {
+			cs = append(cs, &Candidate{Exchange: e, Score: best})
+		}
+	}
+
+	sort.SliceStable(cs, func(i, j int) bool {
+		return cs[i].Score > cs[j].Score
+	})
+
+	if len(cs) == 1 && cs[0].Score == 1 {
+		return cs[0].Exchange, cs
+	}
+	if len(cs) > 1 && cs[0].Score == 1 && cs[1].Score < 1 {
+		return cs[0].Exchange, cs
+	}
+	return "", cs
+}
+
+// headerScore rates how well a row matches a header signature, from 0 to 1
+func headerScore(row, header []string) float64 {
+	if valuesContain(row, header) {
+		return 1
+	}
+
+	matched := 0
+	for i, h := range header {
+		if i >= len(row) {
+			break
+		}
+		if m, _ := regexp.MatchString(h, row[i]); m {
+			matched++
+		}
+	}
+
+	// extra or missing columns count against the match
+	n := len(header)
+	if len(row) > n {
+		n = len(row)
+	}
+	return float64(matched) / float64(n)
+}
@@ -0,0 +1,408 @@
+package parsers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Kraken struct
+type Kraken struct {
+	// Kind of file parsed, trades or ledgers
+	Kind string
+	// ledger entries in file order
+	entries []*krakenLedger
+	// stored ledger entries by txid, to recover the fees of trades
+	ledgers map[string]*krakenLedger
+}
+
+// Kinds of Kraken files. Both hold the same trades, ledgers also have the
+// fees in the currency they were charged in.
+const (
+	KrakenTrades  = "trades"
+	KrakenLedgers = "ledgers"
+)
+
+// krakenLedger is a single row of a Kraken ledgers file
+type krakenLedger struct {
+	Line   int
+	TxID   string
+	RefID  string
+	Date   time.Time
+	Type   string
+	Asset  string
+	Amount decimal.Decimal
+	Fee    decimal.Decimal
+}
+
+// regexp match fields
+var (
+	krakenTradesHeader  = []string{"txid", "ordertxid", "pair", "time", "type", "ordertype", "price", "cost", "fee", "vol", "margin", "misc", "ledgers"}
+	krakenLedgersHeader = []string{"txid", "refid", "time", "type", "subtype", "aclass", "asset", "amount", "fee", "balance"}
+)
+
+// krakenEvents maps the ledger types that aren't trades to event actions
+var krakenEvents = map[string]string{
+	"deposit":    "RECEIVE",
+	"withdrawal": "SEND",
+	"staking":    "STAKING",
+}
+
+// krakenQuotes are the assets Kraken lists markets against.
+// Checked in order, so a symbol must come before any shorter one it ends with.
+var krakenQuotes = []string{
+	"ZCAD", "ZUSD", "ZEUR", "ZGBP", "ZJPY", "XXBT", "XETH",
+	"USDT", "USDC", "DAI", "CAD", "USD", "EUR", "GBP", "JPY", "CHF", "AUD", "XBT", "ETH", "DOT",
+}
+
+// krakenAssets maps Kraken's legacy asset codes to common symbols.
+// Crypto is prefixed with X and fiat with Z.
+var krakenAssets = map[string]string{
+	"XBT":  "BTC",
+	"XDG":  "DOGE",
+	"XXBT": "BTC",
+	"XXDG": "DOGE",
+	"XETC": "ETC",
+	"XETH": "ETH",
+	"XLTC": "LTC",
+	"XMLN": "MLN",
+	"XREP": "REP",
+	"XXLM": "XLM",
+	"XXMR": "XMR",
+	"XXRP": "XRP",
+	"XZEC": "ZEC",
+	"ZAUD": "AUD",
+	"ZCAD": "CAD",
+	"ZEUR": "EUR",
+	"ZGBP": "GBP",
+	"ZJPY": "JPY",
+	"ZUSD": "USD",
+}
+
+// Parse a Kraken trades or ledgers file
+/*
+trades.csv
+txid: trade id
+ordertxid: order id
+pair: trading pair (XETHZCAD)
+time: date
+type: action
+ordertype: market/limit
+price: unit base price
+cost: base amount
+fee: fee amount, in base currency
+vol: amount
+margin: margin amount
+misc: notes
+ledgers: comma separated ledger ids of the trade
+
+ledgers.csv
+txid: ledger id
+refid: trade id, shared by both sides of a trade
+time: date
+type: trade/deposit/withdrawal/...
+subtype: sub type
+aclass: asset class
+asset: currency
+amount: signed amount, excluding fee
+fee: fee amount, in asset
+balance: asset balance after entry
+*/
+func (k *Kraken) Parse(r *csv.Reader) (*Result, error) {
+	res := &Result{}
+
+	// rows before the header may be of any length
+	r.FieldsPerRecord = -1
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+
+		// find which kind of file it is
+		k.Kind = krakenKind(row)
+		if k.Kind == KrakenTrades {
+			k.parseTrades(r, res)
+			break
+		}
+		if k.Kind == KrakenLedgers {
+			k.entries = k.readLedgers(r, res)
+			k.ledgerTrades(res)
+			break
+		}
+	}
+	return res, nil
+}
+
+// LoadLedgers reads a ledgers file already imported, so a trades file
+// parsed next takes its fees from them. Other files are ignored.
+func (k *Kraken) LoadLedgers(r *csv.Reader) {
+	r.FieldsPerRecord = -1
+	for {
+		row, err := r.Read()
+		if err != nil {
+			return
+		}
+		if krakenKind(row) == KrakenLedgers {
+			break
+		}
+	}
+
+	if k.ledgers == nil {
+		k.ledgers = make(map[string]*krakenLedger)
+	}
+	for _, l := range k.readLedgers(r, &Result{}) {
+		k.ledgers[l.TxID] = l
+	}
+}
+
+func krakenKind(header []string) string {
+	if valuesContain(header, krakenTradesHeader) {
+		return KrakenTrades
+	}
+	if valuesContain(header, krakenLedgersHeader) {
+		return KrakenLedgers
+	}
+	return ""
+}
+
+// Headers of a Kraken trades or ledgers file
+func (k *Kraken) Headers() [][]string {
+	return [][]string{krakenTradesHeader, krakenLedgersHeader}
+}
+
+// readLedgers reads the rows of a ledgers file following the header
+func (k *Kraken) readLedgers(r *csv.Reader, res *Result) (ls []*krakenLedger) {
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
+
+		// skip repeated header
+		if len(row) > 0 && row[0] == "txid" {
+			continue
+		}
+
+		if len(row) < len(krakenLedgersHeader) {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), len(krakenLedgersHeader)))
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02 15:04:05", row[2])
+		if err != nil {
+			res.fail(line, "time", row[2], "invalid date")
+		}
+
+		amount, err := decimal.NewFromString(row[7])
+		if err != nil {
+			res.fail(line, "amount", row[7], "invalid number")
+		}
+
+		fee, err := decimal.NewFromString(row[8])
+		if err != nil {
+			res.fail(line, "fee", row[8], "invalid number")
+		}
+
+		if res.failedOn(line) {
+			continue
+		}
+
+		ls = append(ls, &krakenLedger{
+			Line:   line,
+			TxID:   row[0],
+			RefID:  row[1],
+			Date:   date,
+			Type:   strings.ToLower(row[3]),
+			Asset:  krakenAsset(row[6]),
+			Amount: amount,
+			Fee:    fee,
+		})
+	}
+	return
+}
+
+// parseTrades reads the rows of a trades file following the header
+func (k *Kraken) parseTrades(r *csv.Reader, res *Result) {
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
+
+		if len(row) < len(krakenTradesHeader) {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), len(krakenTradesHeader)))
+			continue
+		}
+
+		action := strings.ToUpper(row[4])
+		// skip if not a buy or sell
+		if !ValidAction(action) {
+			res.skip(line, fmt.Sprintf("not a buy or sell: %v", row[4]))
+			continue
+		}
+
+		date, err := time.Parse("2006-01-02 15:04:05", row[3])
+		if err != nil {
+			res.fail(line, "time", row[3], "invalid date")
+		}
+
+		currency, baseCur, err := splitPair(strings.ToUpper(row[2]), krakenQuotes)
+		if err != nil {
+			res.fail(line, "pair", row[2], "unknown trading pair")
+		}
+		currency = krakenAsset(currency)
+		baseCur = krakenAsset(baseCur)
+
+		baseAmt, err := decimal.NewFromString(row[7])
+		if err != nil {
+			res.fail(line, "cost", row[7], "invalid number")
+		}
+
+		feeAmt, err := decimal.NewFromString(row[8])
+		if err != nil {
+			res.fail(line, "fee", row[8], "invalid number")
+		}
+		feeCur := baseCur
+
+		amount, err := decimal.NewFromString(row[9])
+		if err != nil {
+			res.fail(line, "vol", row[9], "invalid number")
+		}
+
+		if res.failedOn(line) {
+			continue
+		}
+
+		// the fee is given in the base currency, the ledgers know the
+		// asset it was actually charged in
+		for _, id := range strings.Split(row[12], ",") {
+			if l, ok := k.ledgers[strings.TrimSpace(id)]; ok && !l.Fee.IsZero() {
+				feeAmt = l.Fee
+				feeCur = l.Asset
+				break
+			}
+		}
+
+		res.Trades = append(res.Trades, Trade{
+			Date:         date,
+			Action:       action,
+			Amount:       amount,
+			Currency:     html.EscapeString(currency),
+			BaseAmount:   baseAmt,
+			BaseCurrency: html.EscapeString(baseCur),
+			FeeAmount:    feeAmt,
+			FeeCurrency:  html.EscapeString(feeCur),
+		})
+	}
+}
+
+// ledgerTrades rebuilds trades from the loaded ledger entries.
+// Both sides of a trade share a refid: one spent, one received.
+// Deposits and withdrawals become events.
+func (k *Kraken) ledgerTrades(res *Result) {
+	sides := make(map[string][]*krakenLedger)
+	var refs []string
+
+	for _, l := range k.entries {
+		// moves between accounts, to be matched as transfers
+		if a, ok := krakenEvents[l.Type]; ok {
+			res.Trades = append(res.Trades, Trade{
+				Date:         l.Date,
+				Action:       a,
+				Amount:       l.Amount.Abs(),
+				Currency:     html.EscapeString(l.Asset),
+				BaseAmount:   decimal.NewFromFloat(0),
+				BaseCurrency: html.EscapeString(l.Asset),
+				FeeAmount:    l.Fee,
+				FeeCurrency:  html.EscapeString(l.Asset),
+			})
+			continue
+		}
+		if l.Type != "trade" {
+			res.skip(l.Line, fmt.Sprintf("not a trade: %v", l.Type))
+			continue
+		}
+		if _, ok := sides[l.RefID]; !ok {
+			refs = append(refs, l.RefID)
+		}
+		sides[l.RefID] = append(sides[l.RefID], l)
+	}
+
+	for _, ref := range refs {
+		ls := sides[ref]
+		if len(ls) != 2 {
+			for _, l := range ls {
+				res.skip(l.Line, fmt.Sprintf("trade %v has %v ledger entries", ref, len(ls)))
+			}
+			continue
+		}
+
+		spent, received := ls[0], ls[1]
+		if spent.Amount.IsPositive() {
+			spent, received = received, spent
+		}
+
+		t := Trade{
+			Date:        received.Date,
+			FeeAmount:   decimal.NewFromFloat(0),
+			FeeCurrency: html.EscapeString(received.Asset),
+		}
+		for _, l := range ls {
+			if !l.Fee.IsZero() {
+				t.FeeAmount = l.Fee
+				t.FeeCurrency = html.EscapeString(l.Asset)
+			}
+		}
+
+		// the side closer to fiat is the base currency
+		if quoteRank(spent.Asset) <= quoteRank(received.Asset) {
+			t.Action = "BUY"
+			t.Amount = received.Amount
+			t.Currency = html.EscapeString(received.Asset)
+			t.BaseAmount = spent.Amount.Neg()
+			t.BaseCurrency = html.EscapeString(spent.Asset)
+		} else {
+			t.Action = "SELL"
+			t.Amount = spent.Amount.Neg()
+			t.Currency = html.EscapeString(spent.Asset)
+			t.BaseAmount = received.Amount
+			t.BaseCurrency = html.EscapeString(received.Asset)
+		}
+
+		res.Trades = append(res.Trades, t)
+	}
+}
+
+// quoteRank orders assets by how likely they are to be the base of a pair
+func quoteRank(asset string) int {
+	for i, q := range []string{"CAD", "USD", "EUR", "GBP", "JPY", "CHF", "AUD", "USDT", "USDC", "DAI", "BTC", "ETH"} {
+		if asset == q {
+			return i
+		}
+	}
+	return 1 << 16
+}
+
+// krakenAsset maps a Kraken asset code to its common symbol, ie: XXBT -> BTC, ZCAD -> CAD
+func krakenAsset(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if a, ok := krakenAssets[code]; ok {
+		return a
+	}
+	return code
+}
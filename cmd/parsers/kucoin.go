@@ -14,6 +14,9 @@ import (
 // Kucoin struct
 type Kucoin struct{}
 
+// regexp match fields
+var kucoinHeader = []string{"Time", "Coins", "Sell/Buy", "Filled Price", "Coin", "Amount", "Coin", "Volume", "Coin", "Fee", "Coin"}
+
 // Parse a Kucoin file
 /*
 Time: date
@@ -28,59 +31,71 @@ Coin: base currency
 Fee: fee amount
 Coin: fee currency
 */
-func (Kucoin) Parse(r *csv.Reader) (trades []Trade, parseError error) {
-	// regexp match fields
-	header := []string{"Time", "Coins", "Sell/Buy", "Filled Price", "Coin", "Amount", "Coin", "Volume", "Coin", "Fee", "Coin"}
+func (Kucoin) Parse(r *csv.Reader) (*Result, error) {
+	res := &Result{}
 	onData := false
 
-	for i := 0; ; i++ {
+	// rows before the header may be of any length
+	r.FieldsPerRecord = -1
+
+	for {
 		row, err := r.Read()
 		if err == io.EOF {
 			break
 		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
 
 		// skip header rows
 		if !onData {
-			if valuesContain(row, header) {
+			if valuesContain(row, kucoinHeader) {
 				onData = true
 			}
 			continue
 		}
 
-		var date time.Time
-		if date, err = time.Parse("2006-01-02 15:04:05", row[0]); err != nil {
-			parseError = fmt.Errorf("time.Parse failed: %v", row[0])
-			break
+		if len(row) < len(kucoinHeader) {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), len(kucoinHeader)))
+			continue
 		}
 
 		action := strings.ToUpper(row[2])
 		// skip if not a buy or sell
 		if !ValidAction(action) {
+			res.skip(line, fmt.Sprintf("not a buy or sell: %v", row[2]))
 			continue
 		}
 
-		var amount decimal.Decimal
-		if amount, err = decimal.NewFromString(row[5]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[5])
-			break
+		date, err := time.Parse("2006-01-02 15:04:05", row[0])
+		if err != nil {
+			res.fail(line, "Time", row[0], "invalid date")
+		}
+
+		amount, err := decimal.NewFromString(row[5])
+		if err != nil {
+			res.fail(line, "Amount", row[5], "invalid number")
 		}
 		currency := strings.ToUpper(html.EscapeString(row[6]))
 
-		var baseAmt decimal.Decimal
-		if baseAmt, err = decimal.NewFromString(row[7]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[7])
-			break
+		baseAmt, err := decimal.NewFromString(row[7])
+		if err != nil {
+			res.fail(line, "Volume", row[7], "invalid number")
 		}
 		baseCur := strings.ToUpper(html.EscapeString(row[8]))
 
-		var feeAmt decimal.Decimal
-		if feeAmt, err = decimal.NewFromString(row[9]); err != nil {
-			parseError = fmt.Errorf("decimal.NewFromString failed: %v", row[9])
-			break
+		feeAmt, err := decimal.NewFromString(row[9])
+		if err != nil {
+			res.fail(line, "Fee", row[9], "invalid number")
 		}
 		feeCur := strings.ToUpper(html.EscapeString(row[10]))
 
-		trades = append(trades, Trade{
+		if res.failedOn(line) {
+			continue
+		}
+
+		res.Trades = append(res.Trades, Trade{
 			Date:         date,
 			Action:       action,
 			Amount:       amount,
@@ -91,5 +106,10 @@ func (Kucoin) Parse(r *csv.Reader) (trades []Trade, parseError error) {
 			FeeCurrency:  feeCur,
 		})
 	}
-	return
+	return res, nil
+}
+
+// Headers of a Kucoin file
+func (Kucoin) Headers() [][]string {
+	return [][]string{kucoinHeader}
 }
@@ -0,0 +1,198 @@
+package parsers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Mapped parses any file using a user's mapping profile
+type Mapped struct {
+	Profile *models.Profile
+}
+
+// date layouts tried when the profile doesn't have one
+var mappedLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"01/02/2006 15:04:05",
+	"01/02/2006",
+	"2006/01/02 15:04:05",
+	"2006/01/02",
+}
+
+// Parse a file by the profile's column names
+func (m *Mapped) Parse(r *csv.Reader) (*Result, error) {
+	if m.Profile == nil {
+		return nil, fmt.Errorf("Missing mapping profile")
+	}
+	p := m.Profile
+	res := &Result{}
+
+	layouts := mappedLayouts
+	if p.DateLayout != "" {
+		layouts = []string{p.DateLayout}
+	}
+	buys, sells := p.Buys(), p.Sells()
+
+	// rows before the header may be of any length
+	r.FieldsPerRecord = -1
+
+	var col map[string]int
+	var header []string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		line := lineOf(r, err)
+		if res.malformed(line, err) {
+			continue
+		}
+
+		// skip header rows
+		if col == nil {
+			col = mappedColumns(row, p)
+			header = row
+			continue
+		}
+
+		// ignore short lines
+		if len(row) <= col["max"] {
+			res.skip(line, fmt.Sprintf("%v of %v columns", len(row), col["max"]+1))
+			continue
+		}
+
+		// fail records the value of a column that couldn't be read
+		fail := func(c int, reason string) {
+			res.fail(line, header[c], row[c], reason)
+		}
+
+		var action string
+		a := strings.ToLower(strings.TrimSpace(row[col["action"]]))
+		if includesWord(buys, a) {
+			action = "BUY"
+		} else if includesWord(sells, a) {
+			action = "SELL"
+		} else {
+			res.skip(line, fmt.Sprintf("not a buy or sell: %v", row[col["action"]]))
+			continue
+		}
+
+		date, err := parseTime(row[col["date"]], layouts...)
+		if err != nil {
+			fail(col["date"], "invalid date")
+		}
+
+		amount, err := decimal.NewFromString(cleanMoney(row[col["amount"]]))
+		if err != nil {
+			fail(col["amount"], "invalid number")
+		}
+		currency := strings.ToUpper(html.EscapeString(strings.TrimSpace(row[col["currency"]])))
+
+		baseAmt, err := decimal.NewFromString(cleanMoney(row[col["baseAmount"]]))
+		if err != nil {
+			fail(col["baseAmount"], "invalid number")
+		}
+		baseCur := strings.ToUpper(html.EscapeString(strings.TrimSpace(row[col["baseCurrency"]])))
+
+		feeAmt := decimal.NewFromFloat(0)
+		if i, ok := col["feeAmount"]; ok {
+			if feeAmt, err = decimal.NewFromString(cleanMoney(row[i])); err != nil {
+				fail(i, "invalid number")
+			}
+		}
+		feeCur := baseCur
+		if i, ok := col["feeCurrency"]; ok && strings.TrimSpace(row[i]) != "" {
+			feeCur = strings.ToUpper(html.EscapeString(strings.TrimSpace(row[i])))
+		}
+
+		if res.failedOn(line) {
+			continue
+		}
+
+		res.Trades = append(res.Trades, Trade{
+			Date:         date,
+			Action:       action,
+			Amount:       amount.Abs(),
+			Currency:     currency,
+			BaseAmount:   baseAmt.Abs(),
+			BaseCurrency: baseCur,
+			FeeAmount:    feeAmt.Abs(),
+			FeeCurrency:  feeCur,
+		})
+	}
+
+	if col == nil {
+		return nil, fmt.Errorf("Couldn't find the columns of profile %v", p.Name)
+	}
+	return res, nil
+}
+
+// mappedColumns returns the position of each profile column if the row is
+// the header, or nil. "max" holds the right-most required position.
+func mappedColumns(row []string, p *models.Profile) map[string]int {
+	find := func(name string) int {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return -1
+		}
+		for i, c := range row {
+			if strings.ToLower(strings.TrimSpace(c)) == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	col := make(map[string]int)
+	required := map[string]string{
+		"date":         p.DateColumn,
+		"action":       p.ActionColumn,
+		"amount":       p.AmountColumn,
+		"currency":     p.CurrencyColumn,
+		"baseAmount":   p.BaseAmountColumn,
+		"baseCurrency": p.BaseCurrencyColumn,
+	}
+	for k, name := range required {
+		i := find(name)
+		if i < 0 {
+			return nil
+		}
+		col[k] = i
+		if i > col["max"] {
+			col["max"] = i
+		}
+	}
+
+	optional := map[string]string{
+		"feeAmount":   p.FeeAmountColumn,
+		"feeCurrency": p.FeeCurrencyColumn,
+	}
+	for k, name := range optional {
+		if i := find(name); i > -1 {
+			col[k] = i
+			if i > col["max"] {
+				col["max"] = i
+			}
+		}
+	}
+
+	return col
+}
+
+func includesWord(ws []string, w string) bool {
+	for _, x := range ws {
+		if x == w {
+			return true
+		}
+	}
+	return false
+}
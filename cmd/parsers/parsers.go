@@ -3,18 +3,21 @@
 package parsers
 
 import (
+	"encoding/csv"
 	"errors"
+	"fmt"
 	"regexp"
 	"strings"
 	"time"
 
+	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
 
 // Trade is the common structure for an exchange action
 type Trade struct {
 	Date         time.Time       // Date of trade
-	Action       string          // Buy/Sell
+	Action       string          // Buy/Sell, or a non-trade event
 	Amount       decimal.Decimal // Number of tokens traded
 	Currency     string          // Token traded
 	BaseAmount   decimal.Decimal // Amount paid in base currency
@@ -23,6 +26,70 @@ type Trade struct {
 	FeeCurrency  string          // Trade fee currency
 }
 
+// Result of parsing a file: the accepted trades, and the rows that weren't
+type Result struct {
+	Trades  []Trade     `json:"-"`
+	Skipped []*RowNote  `json:"skipped"`
+	Failed  []*RowError `json:"failed"`
+}
+
+// RowNote explains why a row was skipped
+type RowNote struct {
+	Line   int    `json:"line"`
+	Reason string `json:"reason"`
+}
+
+// RowError describes a value that couldn't be read
+type RowError struct {
+	Line   int    `json:"line"`
+	Column string `json:"column"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("Line %v: %v", e.Line, e.Reason)
+	}
+	return fmt.Sprintf("Line %v, %v: %v (%v)", e.Line, e.Column, e.Reason, e.Value)
+}
+
+// skip notes a row that isn't a trade
+func (res *Result) skip(line int, reason string) {
+	res.Skipped = append(res.Skipped, &RowNote{Line: line, Reason: reason})
+}
+
+// fail notes a value that couldn't be read
+func (res *Result) fail(line int, column, value, reason string) {
+	res.Failed = append(res.Failed, &RowError{Line: line, Column: column, Value: value, Reason: reason})
+}
+
+// malformed notes a row the CSV reader couldn't split, like an unbalanced
+// quote, as failed
+func (res *Result) malformed(line int, err error) bool {
+	e, ok := err.(*csv.ParseError)
+	if !ok {
+		return false
+	}
+	res.Failed = append(res.Failed, &RowError{Line: line, Reason: e.Err.Error()})
+	return true
+}
+
+// failedOn reports whether any value of the line failed
+func (res *Result) failedOn(line int) bool {
+	n := len(res.Failed)
+	return n > 0 && res.Failed[n-1].Line == line
+}
+
+// lineOf is the file line of the row last read, blank lines included
+func lineOf(r *csv.Reader, err error) int {
+	if e, ok := err.(*csv.ParseError); ok {
+		return e.StartLine
+	}
+	line, _ := r.FieldPos(0)
+	return line
+}
+
 // NewParser returns the matching type of parser
 func NewParser(name string) (p interface{}, err error) {
 	switch name {
@@ -30,6 +97,10 @@ func NewParser(name string) (p interface{}, err error) {
 		p = &Coinbase{}
 	case "Kucoin":
 		p = &Kucoin{}
+	case "Binance":
+		p = &Binance{}
+	case "Kraken":
+		p = &Kraken{}
 	case "Cryptotax":
 		p = &Custom{}
 	default:
@@ -44,6 +115,22 @@ func ValidAction(a string) bool {
 	return a == "BUY" || a == "SELL"
 }
 
+// ValidEvent is a non-trade action: coins sent, received or earned
+func ValidEvent(a string) bool {
+	a = strings.ToUpper(a)
+	return a == "SEND" || a == "RECEIVE" || models.IsIncome(a)
+}
+
+// parseTime tries each layout in order
+func parseTime(s string, layouts ...string) (t time.Time, err error) {
+	for _, l := range layouts {
+		if t, err = time.Parse(l, strings.TrimSpace(s)); err == nil {
+			return
+		}
+	}
+	return t, fmt.Errorf("time.Parse failed: %v", s)
+}
+
 // match: "Transacted CAD" with "Transacted"
 func valuesContain(full, test []string) bool {
 	if len(full) != len(test) {
@@ -0,0 +1,373 @@
+package parsers
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestSplitPair(t *testing.T) {
+	tcs := []struct {
+		Pair  string
+		Curr  string
+		Base  string
+		Error bool
+	}{
+		{Pair: "ETHBTC", Curr: "ETH", Base: "BTC"},
+		{Pair: "BTCUSDT", Curr: "BTC", Base: "USDT"},
+		{Pair: "BNBETH", Curr: "BNB", Base: "ETH"},
+		{Pair: "XRPBNB", Curr: "XRP", Base: "BNB"},
+		{Pair: "BTC", Error: true},
+		{Pair: "ABCXYZ", Error: true},
+	}
+
+	for _, tc := range tcs {
+		c, b, err := splitPair(tc.Pair, binanceQuotes)
+		if tc.Error {
+			if err == nil {
+				t.Errorf("Should error on %v", tc.Pair)
+			}
+			continue
+		}
+		if c != tc.Curr || b != tc.Base {
+			t.Errorf("Wrong split of %v. Got: %v/%v, want: %v/%v", tc.Pair, c, b, tc.Curr, tc.Base)
+		}
+	}
+}
+
+func TestBinanceParse(t *testing.T) {
+	f := `Date(UTC),Pair,Side,Price,Executed,Amount,Fee
+2018-01-10 14:29:45,ETHBTC,BUY,0.0875,2.5ETH,0.21875BTC,0.0025ETH
+2018-02-11 08:02:11,TRXUSDT,SELL,0.05,"1,000TRX",50USDT,0.01BNB
+2021-03-01 10:00:00,1INCHUSDT,BUY,4.5,0.51INCH,2.295USDT,0.00051INCH
+`
+	res, err := Binance{}.Parse(csv.NewReader(strings.NewReader(f)))
+	if err != nil {
+		t.Fatalf("Should parse. Got: %v", err)
+	}
+	ts := res.Trades
+	if len(ts) != 3 {
+		t.Fatalf("Should have 3 trades, not %v", len(ts))
+	}
+
+	x := ts[0]
+	if x.Action != "BUY" || x.Currency != "ETH" || x.BaseCurrency != "BTC" || x.FeeCurrency != "ETH" {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	if !x.Amount.Equal(decimal.NewFromFloat(2.5)) || !x.BaseAmount.Equal(decimal.NewFromFloat(0.21875)) {
+		t.Errorf("Wrong trade amounts: %v, %v", x.Amount, x.BaseAmount)
+	}
+
+	x = ts[1]
+	if x.Action != "SELL" || x.Currency != "TRX" || x.BaseCurrency != "USDT" || x.FeeCurrency != "BNB" {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	if !x.Amount.Equal(decimal.NewFromFloat(1000)) {
+		t.Errorf("Wrong amount. Got: %v, want: %v", x.Amount, 1000)
+	}
+	// a symbol starting with a digit keeps its amount
+	x = ts[2]
+	if x.Currency != "1INCH" || x.BaseCurrency != "USDT" || x.FeeCurrency != "1INCH" {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	if !x.Amount.Equal(decimal.NewFromFloat(0.5)) || !x.FeeAmount.Equal(decimal.NewFromFloat(0.0005)) {
+		t.Errorf("Wrong trade amounts: %v, %v", x.Amount, x.FeeAmount)
+	}
+}
+
+func TestKrakenAsset(t *testing.T) {
+	tcs := map[string]string{
+		"XXBT": "BTC",
+		"XBT":  "BTC",
+		"XETH": "ETH",
+		"ZCAD": "CAD",
+		"ZUSD": "USD",
+		"DOT":  "DOT",
+		"USDT": "USDT",
+	}
+
+	for code, exp := range tcs {
+		if act := krakenAsset(code); act != exp {
+			t.Errorf("Wrong asset for %v. Got: %v, want: %v", code, act, exp)
+		}
+	}
+}
+
+func TestKrakenTradesWithLedgers(t *testing.T) {
+	l := `"txid","refid","time","type","subtype","aclass","asset","amount","fee","balance"
+"L1","T1","2018-01-05 10:00:00.1234","trade","","currency","ZCAD","-1000.0000","0.0000","500.0000"
+"L2","T1","2018-01-05 10:00:00.1234","trade","","currency","XETH","1.0000000000","0.0020000000","1.0000000000"
+"L3","T2","2018-02-05 10:00:00.1234","trade","","currency","XETH","-0.5000000000","0.0000000000","0.5000000000"
+"L4","T2","2018-02-05 10:00:00.1234","trade","","currency","XXBT","0.0500000000","0.0001000000","0.0500000000"
+`
+	tr := `"txid","ordertxid","pair","time","type","ordertype","price","cost","fee","vol","margin","misc","ledgers"
+"T1","O1","XETHZCAD","2018-01-05 10:00:00.1234","buy","market","1000.00","1000.00","2.60","1.00000000","0.00","","L1,L2"
+"T2","O2","XETHXXBT","2018-02-05 10:00:00.1234","sell","limit","0.1","0.05","0.0001","0.50000000","0.00","","L3,L4"
+`
+	// each upload gets its own parser, like the app does
+	parser := func() *Kraken {
+		p, err := NewParser("Kraken")
+		if err != nil {
+			t.Fatalf("Should get a parser. Got: %v", err)
+		}
+		return p.(*Kraken)
+	}
+
+	// ledgers alone rebuild the trades, with fees in the charged currency
+	k := parser()
+	res, err := k.Parse(csv.NewReader(strings.NewReader(l)))
+	if err != nil {
+		t.Fatalf("Should parse ledgers. Got: %v", err)
+	}
+	if k.Kind != KrakenLedgers {
+		t.Errorf("Wrong kind. Got: %v, want: %v", k.Kind, KrakenLedgers)
+	}
+	ts := res.Trades
+	if len(ts) != 2 {
+		t.Fatalf("Should have 2 trades, not %v", len(ts))
+	}
+	if x := ts[0]; x.Action != "BUY" || x.Currency != "ETH" || x.BaseCurrency != "CAD" || x.FeeCurrency != "ETH" || !x.FeeAmount.Equal(decimal.NewFromFloat(0.002)) {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	if x := ts[1]; x.Action != "SELL" || x.Currency != "ETH" || x.BaseCurrency != "BTC" || !x.Amount.Equal(decimal.NewFromFloat(0.5)) {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+
+	// trades alone have their fee in the base currency
+	k = parser()
+	res, err = k.Parse(csv.NewReader(strings.NewReader(tr)))
+	if err != nil {
+		t.Fatalf("Should parse trades. Got: %v", err)
+	}
+	if k.Kind != KrakenTrades {
+		t.Errorf("Wrong kind. Got: %v, want: %v", k.Kind, KrakenTrades)
+	}
+	if x := res.Trades[0]; x.FeeCurrency != "CAD" || !x.FeeAmount.Equal(decimal.NewFromFloat(2.6)) {
+		t.Errorf("Fee should be in the base currency. Got: %v %v", x.FeeAmount, x.FeeCurrency)
+	}
+
+	// trades take their fee from the stored ledgers, other files are ignored
+	k = parser()
+	k.LoadLedgers(csv.NewReader(strings.NewReader(tr)))
+	k.LoadLedgers(csv.NewReader(strings.NewReader(l)))
+	res, err = k.Parse(csv.NewReader(strings.NewReader(tr)))
+	if err != nil {
+		t.Fatalf("Should parse trades. Got: %v", err)
+	}
+	ts = res.Trades
+	if len(ts) != 2 {
+		t.Fatalf("Should have 2 trades, not %v", len(ts))
+	}
+	if x := ts[0]; x.FeeCurrency != "ETH" || !x.FeeAmount.Equal(decimal.NewFromFloat(0.002)) {
+		t.Errorf("Fee should come from ledger. Got: %v %v", x.FeeAmount, x.FeeCurrency)
+	}
+	if x := ts[1]; x.Currency != "ETH" || x.BaseCurrency != "BTC" || x.FeeCurrency != "BTC" || !x.FeeAmount.Equal(decimal.NewFromFloat(0.0001)) {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+}
+
+func TestCoinbase2018Parse(t *testing.T) {
+	f := `Timestamp,Transaction Type,Asset,Quantity Transacted,CAD Spot Price at Transaction,CAD Amount Transacted (Inclusive of Coinbase Fees),Address,Notes
+2018-01-10T14:29:45Z,Buy,BTC,0.01,18000.00,190.00,,Bought 0.01 BTC for $190.00 CAD
+2018-01-11T09:10:11Z,Coinbase Earn,XLM,10,0.50,,,Received 10 XLM from Coinbase Earn
+2018-01-12T09:10:11Z,Send,BTC,0.005,18500.00,,1abc,Sent 0.005 BTC
+`
+	res, err := Coinbase{}.Parse(csv.NewReader(strings.NewReader(f)))
+	if err != nil {
+		t.Fatalf("Should parse. Got: %v", err)
+	}
+	ts := res.Trades
+	if len(ts) != 3 {
+		t.Fatalf("Should have 3 entries, not %v", len(ts))
+	}
+
+	// the fee of a trade is derived from its total
+	if x := ts[0]; x.Action != "BUY" || !x.FeeAmount.Equal(decimal.NewFromFloat(10)) || x.FeeCurrency != "CAD" {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	// rewards and transfers have no total, and no fee
+	if x := ts[1]; x.Action != "REWARD" || !x.FeeAmount.IsZero() {
+		t.Errorf("Reward should have no fee: %+v", x)
+	}
+	if x := ts[2]; x.Action != "SEND" || !x.FeeAmount.IsZero() {
+		t.Errorf("Send should have no fee: %+v", x)
+	}
+}
+
+func TestCoinbaseParse(t *testing.T) {
+	f := `You can use this transaction report to inform your likely tax obligations.
+
+Transactions
+User,name@example.com,abc123
+
+Timestamp,Transaction Type,Asset,Quantity Transacted,CAD Spot Price at Transaction,CAD Subtotal,CAD Total (inclusive of fees),CAD Fees,Notes
+2021-03-01T15:04:05Z,Buy,BTC,0.01,60000.00,600.00,610.00,10.00,Bought 0.01 BTC for $610.00 CAD
+2021-03-02T09:10:11Z,Convert,BTC,0.005,61000.00,300.00,305.00,5.00,Converted 0.005 BTC to 0.15 ETH
+2021-03-03T09:10:11Z,Rewards Income,XLM,10,0.50,5.00,5.00,0.00,Received 10 XLM from Coinbase Earn
+2021-03-04T09:10:11Z,Send,ETH,0.1,2000.00,200.00,200.00,0.00,Sent 0.1 ETH to 0xabc
+2021-03-05T09:10:11Z,Unknown Type,ETH,0.1,2000.00,200.00,200.00,0.00,
+`
+	res, err := Coinbase{}.Parse(csv.NewReader(strings.NewReader(f)))
+	if err != nil {
+		t.Fatalf("Should parse. Got: %v", err)
+	}
+	ts := res.Trades
+	if len(ts) != 4 {
+		t.Fatalf("Should have 4 entries, not %v", len(ts))
+	}
+
+	x := ts[0]
+	if x.Date.Hour() != 15 || x.Action != "BUY" || x.BaseCurrency != "CAD" {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	if !x.BaseAmount.Equal(decimal.NewFromFloat(600)) || !x.FeeAmount.Equal(decimal.NewFromFloat(10)) {
+		t.Errorf("Wrong trade amounts: %v, %v", x.BaseAmount, x.FeeAmount)
+	}
+
+	x = ts[1]
+	if x.Action != "SELL" || x.Currency != "BTC" || x.BaseCurrency != "ETH" || !x.BaseAmount.Equal(decimal.NewFromFloat(0.15)) {
+		t.Errorf("Convert should be a cross sell: %+v", x)
+	}
+
+	if x = ts[2]; x.Action != "REWARD" || !ValidEvent(x.Action) {
+		t.Errorf("Should be a reward event: %+v", x)
+	}
+	if x = ts[3]; x.Action != "SEND" || !ValidEvent(x.Action) {
+		t.Errorf("Should be a send event: %+v", x)
+	}
+	// lines are counted in the file, blank ones included
+	if len(res.Skipped) != 1 || res.Skipped[0].Line != 11 {
+		t.Errorf("Should skip line 11. Got: %+v", res.Skipped)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	exchanges := []string{"Coinbase", "Kucoin", "Binance", "Kraken", "Cryptotax"}
+
+	tcs := []struct {
+		File     string
+		Exchange string
+	}{
+		{File: "Time,Coins,Sell/Buy,Filled Price,Coin,Amount,Coin,Volume,Coin,Fee,Coin\n", Exchange: "Kucoin"},
+		{File: "Date(UTC),Pair,Side,Price,Executed,Amount,Fee\n", Exchange: "Binance"},
+		{File: "Transactions\nUser,a@b.c,1\n\nTimestamp,Transaction Type,Asset,Quantity Transacted,CAD Spot Price at Transaction,CAD Amount Transacted (Inclusive of Coinbase Fees),Address,Notes\n", Exchange: "Coinbase"},
+		{File: "\"txid\",\"refid\",\"time\",\"type\",\"subtype\",\"aclass\",\"asset\",\"amount\",\"fee\",\"balance\"\n", Exchange: "Kraken"},
+		{File: "date,action,amount,currency,base_amount,base_currency,fee_amount,fee_currency\n", Exchange: "Cryptotax"},
+	}
+
+	for _, tc := range tcs {
+		e, _ := Detect(csv.NewReader(strings.NewReader(tc.File)), exchanges)
+		if e != tc.Exchange {
+			t.Errorf("Wrong exchange detected. Got: %v, want: %v", e, tc.Exchange)
+		}
+	}
+
+	// a Binance file with an extra column is unclear, but still a candidate
+	e, cs := Detect(csv.NewReader(strings.NewReader("Date(UTC),Pair,Side,Price,Executed,Amount,Fee,Total\n")), exchanges)
+	if e != "" {
+		t.Errorf("Should not pick an exchange. Got: %v", e)
+	}
+	if len(cs) == 0 || cs[0].Exchange != "Binance" {
+		t.Errorf("Binance should be the top candidate. Got: %v", cs)
+	}
+}
+
+func TestMappedParse(t *testing.T) {
+	p := &models.Profile{
+		Name:               "OTC Desk",
+		DateColumn:         "Trade Date",
+		DateLayout:         "02/01/2006",
+		ActionColumn:       "Side",
+		BuyWords:           "Bought, Bid",
+		SellWords:          "Sold",
+		AmountColumn:       "Qty",
+		CurrencyColumn:     "Coin",
+		BaseAmountColumn:   "Total",
+		BaseCurrencyColumn: "Fiat",
+		FeeAmountColumn:    "Commission",
+	}
+	f := `OTC Desk statement
+Trade Date,Coin,Side,Qty,Fiat,Total,Commission
+15/03/2018,BTC,Bought,0.5,CAD,"$5,000.00",25
+16/03/2018,ETH,Sold,-2,CAD,1500,10
+17/03/2018,ETH,Deposit,2,CAD,0,0
+`
+	res, err := (&Mapped{Profile: p}).Parse(csv.NewReader(strings.NewReader(f)))
+	if err != nil {
+		t.Fatalf("Should parse. Got: %v", err)
+	}
+	ts := res.Trades
+	if len(ts) != 2 {
+		t.Fatalf("Should have 2 trades, not %v", len(ts))
+	}
+
+	x := ts[0]
+	if x.Action != "BUY" || x.Currency != "BTC" || x.BaseCurrency != "CAD" || x.FeeCurrency != "CAD" || x.Date.Day() != 15 {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+	if !x.BaseAmount.Equal(decimal.NewFromFloat(5000)) || !x.FeeAmount.Equal(decimal.NewFromFloat(25)) {
+		t.Errorf("Wrong trade amounts: %v, %v", x.BaseAmount, x.FeeAmount)
+	}
+	if x = ts[1]; x.Action != "SELL" || !x.Amount.Equal(decimal.NewFromFloat(2)) {
+		t.Errorf("Wrong trade values: %+v", x)
+	}
+
+	// file without the mapped columns
+	if _, err = (&Mapped{Profile: p}).Parse(csv.NewReader(strings.NewReader("a,b,c\n1,2,3\n"))); err == nil {
+		t.Errorf("Should error when columns are missing")
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	f := `date,action,amount,currency,base_amount,base_currency,fee_amount,fee_currency
+2018-01-10,BUY,1.5,ETH,1500,CAD,5,CAD
+2018-01-11,DEPOSIT,1,ETH,0,CAD,0,CAD
+2018-13-12,SELL,abc,ETH,1000,CAD,5,CAD
+2018-01-13,SELL,1,ETH
+2018-01-14,SELL,1,ETH,1000,CAD,5,CAD
+`
+	res, err := Custom{}.Parse(csv.NewReader(strings.NewReader(f)))
+	if err != nil {
+		t.Fatalf("Should parse. Got: %v", err)
+	}
+	if len(res.Trades) != 2 {
+		t.Errorf("Should keep 2 trades, not %v", len(res.Trades))
+	}
+	if len(res.Skipped) != 2 || res.Skipped[0].Line != 3 || res.Skipped[1].Line != 5 {
+		t.Errorf("Should skip lines 3 and 5. Got: %+v", res.Skipped)
+	}
+
+	// every bad value of the line is reported
+	if len(res.Failed) != 2 {
+		t.Fatalf("Should fail 2 values, not %v", len(res.Failed))
+	}
+	if e := res.Failed[0]; e.Line != 4 || e.Column != "date" || e.Value != "2018-13-12" {
+		t.Errorf("Wrong failure: %v", e)
+	}
+	if e := res.Failed[1]; e.Line != 4 || e.Column != "amount" || e.Value != "abc" {
+		t.Errorf("Wrong failure: %v", e)
+	}
+}
+
+func TestParseMalformedRow(t *testing.T) {
+	f := `date,action,amount,currency,base_amount,base_currency,fee_amount,fee_currency
+2018-01-10,BUY,1.5,ETH,1500,CAD,5,CAD
+2018-01-11,SELL,1,"ETH,1000,CAD,5,CAD
+`
+	res, err := Custom{}.Parse(csv.NewReader(strings.NewReader(f)))
+	if err != nil {
+		t.Fatalf("Should parse. Got: %v", err)
+	}
+	if len(res.Trades) != 1 {
+		t.Errorf("Should keep 1 trade, not %v", len(res.Trades))
+	}
+	if len(res.Skipped) != 0 {
+		t.Errorf("Shouldn't skip the unbalanced quote. Got: %+v", res.Skipped)
+	}
+	if len(res.Failed) != 1 || res.Failed[0].Line != 3 {
+		t.Fatalf("Should fail line 3. Got: %+v", res.Failed)
+	}
+}
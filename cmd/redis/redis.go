@@ -30,7 +30,8 @@ func New() *cache.Codec {
 	return codec
 }
 
-// Key formats the values into a consistent key format
-func Key(from, to string, date time.Time) string {
-	return fmt.Sprintf("%v%v%v", from, to, date.Unix())
+// Key formats the values into a consistent key format, separate for
+// each granularity of rate
+func Key(from, to string, date time.Time, granularity string) string {
+	return fmt.Sprintf("%v%v%v%v", from, to, date.Unix(), granularity)
 }
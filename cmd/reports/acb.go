@@ -1,28 +1,73 @@
 package reports
 
 import (
+	"errors"
 	"time"
 
+	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
 
+// ACB report of the sales in the period, at average cost
 type ACB struct {
 	Currency string
+	From     time.Time // start of the period, all sales when zero
 	AsOf     time.Time
 	Items    []*ACBItem
+	Total    decimal.Decimal // net income of the period
 }
 
+// ACBItem is a sale and the cost of the units sold
 type ACBItem struct {
-	Asset     string
-	Acquired  int
-	Amount    decimal.Decimal
-	Proceeds  decimal.Decimal
-	ACB       decimal.Decimal
-	Expenses  decimal.Decimal
-	NetIncome decimal.Decimal
-	Gain      decimal.Decimal
+	Date        time.Time
+	Asset       string
+	Acquired    int // year the units were first held
+	Amount      decimal.Decimal
+	Proceeds    decimal.Decimal
+	ACB         decimal.Decimal
+	Expenses    decimal.Decimal
+	Superficial decimal.Decimal // loss denied
+	NetIncome   decimal.Decimal // gain or loss, without the denied part
+	Gain        decimal.Decimal // net income over the ACB
 }
 
-func (r *ACB) Build() (string, time.Time) {
-	return r.Currency, r.AsOf
+// Build the report
+func (r *ACB) Build(ts []*models.Trade, c Converter) error {
+	if r.Currency == "" {
+		return errors.New("Invalid currency")
+	}
+
+	trades, err := expandAgainstBase(ts, r.Currency, c)
+	if err != nil {
+		return err
+	}
+	_, _, ds, err := tally(trades, r.AsOf)
+	if err != nil {
+		return err
+	}
+
+	r.Items = nil
+	r.Total = decimal.NewFromFloat(0)
+	for _, d := range ds {
+		if d.Date.Before(r.From) {
+			continue
+		}
+		i := &ACBItem{
+			Date:        d.Date,
+			Asset:       d.Asset,
+			Acquired:    d.Acquired.Year(),
+			Amount:      d.Amount,
+			Proceeds:    d.Proceeds,
+			ACB:         d.ACB,
+			Expenses:    d.Expenses,
+			Superficial: d.Superficial,
+			NetIncome:   d.Gain,
+		}
+		if !d.ACB.IsZero() {
+			i.Gain = d.Gain.Div(d.ACB)
+		}
+		r.Items = append(r.Items, i)
+		r.Total = r.Total.Add(d.Gain)
+	}
+	return nil
 }
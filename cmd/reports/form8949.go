@@ -0,0 +1,153 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"strconv"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Form8949 lists the sales of a tax year as IRS Form 8949 does, short term
+// in Part I and long term in Part II, with the Schedule D totals. Crypto
+// isn't reported on a 1099-B, so its boxes are C and F. A loss denied
+// because the asset was bought back is adjusted with code W.
+type Form8949 struct {
+	Currency   string
+	Method     string
+	Selections []*models.LotSelection
+	Year       int
+	ShortTerm  []*Form8949Row // Part I
+	LongTerm   []*Form8949Row // Part II
+}
+
+// Form8949Row is a line of Form 8949, columns (a) to (h)
+type Form8949Row struct {
+	Description string
+	Acquired    string
+	Sold        string
+	Proceeds    decimal.Decimal
+	Cost        decimal.Decimal // with the selling expenses
+	Code        string
+	Adjustment  decimal.Decimal
+	Gain        decimal.Decimal
+}
+
+// Build the form
+func (r *Form8949) Build(ts []*models.Trade, c Converter) error {
+	if r.Currency == "" {
+		return errors.New("Invalid currency")
+	}
+	if r.Year == 0 {
+		return errors.New("Invalid tax year")
+	}
+
+	trades, ds, err := lotDispositions(ts, r.Currency, r.Method, r.Selections, c)
+	if err != nil {
+		return err
+	}
+
+	// the sales the lots were taken from, fees paid aren't sales
+	sales := make(map[uint][]*models.Trade)
+	for _, t := range trades {
+		if t.Action == "SELL" {
+			sales[t.ID] = append(sales[t.ID], t)
+		}
+	}
+
+	r.ShortTerm, r.LongTerm = nil, nil
+	for _, d := range ds {
+		if d.Date.Year() != r.Year {
+			continue
+		}
+		row := &Form8949Row{
+			Description: d.Amount.String() + " " + d.Asset,
+			Acquired:    d.Acquired.Format("01/02/2006"),
+			Sold:        d.Date.Format("01/02/2006"),
+			Proceeds:    d.Proceeds.Round(2),
+			Cost:        d.ACB.Add(d.Expenses).Round(2),
+			Adjustment:  decimal.NewFromFloat(0),
+		}
+		if d.Gain.IsNegative() {
+			for _, s := range sales[d.SaleID] {
+				if s.Currency != d.Asset || !s.Date.Equal(d.Date) {
+					continue
+				}
+				if denied := DeniedLoss(trades, s, d.Gain.Neg()); denied.IsPositive() {
+					row.Code = "W"
+					row.Adjustment = denied.Round(2)
+				}
+				break
+			}
+		}
+		row.Gain = row.Proceeds.Sub(row.Cost).Add(row.Adjustment)
+		if d.LongTerm {
+			r.LongTerm = append(r.LongTerm, row)
+		} else {
+			r.ShortTerm = append(r.ShortTerm, row)
+		}
+	}
+	return nil
+}
+
+// Total of the rows, as carried to Schedule D
+func Total(rows []*Form8949Row) *Form8949Row {
+	t := &Form8949Row{Description: "Totals"}
+	for _, row := range rows {
+		t.Proceeds = t.Proceeds.Add(row.Proceeds)
+		t.Cost = t.Cost.Add(row.Cost)
+		t.Adjustment = t.Adjustment.Add(row.Adjustment)
+		t.Gain = t.Gain.Add(row.Gain)
+	}
+	return t
+}
+
+// CSV writes Part I, Part II and the Schedule D totals
+func (r *Form8949) CSV() ([]byte, error) {
+	header := []string{"(a) Description of property", "(b) Date acquired", "(c) Date sold or disposed of", "(d) Proceeds", "(e) Cost or other basis", "(f) Code", "(g) Amount of adjustment", "(h) Gain or (loss)"}
+	line := func(row *Form8949Row) []string {
+		adj := ""
+		if !row.Adjustment.IsZero() || row.Code != "" {
+			adj = row.Adjustment.StringFixed(2)
+		}
+		return []string{row.Description, row.Acquired, row.Sold, row.Proceeds.StringFixed(2), row.Cost.StringFixed(2), row.Code, adj, row.Gain.StringFixed(2)}
+	}
+	part := func(title string, rows []*Form8949Row) [][]string {
+		records := [][]string{{title}, header}
+		for _, row := range rows {
+			records = append(records, line(row))
+		}
+		return append(records, line(Total(rows)), []string{})
+	}
+
+	y := strconv.Itoa(r.Year)
+	records := [][]string{{"Form 8949 " + y + ", amounts in " + r.Currency}, {}}
+	records = append(records, part("Part I - Short-term, box C", r.ShortTerm)...)
+	records = append(records, part("Part II - Long-term, box F", r.LongTerm)...)
+
+	st, lt := Total(r.ShortTerm), Total(r.LongTerm)
+	sd := func(l string, t *Form8949Row) []string {
+		return []string{l, t.Proceeds.StringFixed(2), t.Cost.StringFixed(2), t.Adjustment.StringFixed(2), t.Gain.StringFixed(2)}
+	}
+	records = append(records,
+		[]string{"Schedule D " + y},
+		[]string{"Line", "(d) Proceeds", "(e) Cost or other basis", "(g) Adjustments", "(h) Gain or (loss)"},
+		sd("3", st),
+		sd("10", lt),
+		[]string{"7", "", "", "", st.Gain.StringFixed(2)},
+		[]string{"15", "", "", "", lt.Gain.StringFixed(2)},
+		[]string{"16", "", "", "", st.Gain.Add(lt.Gain).StringFixed(2)},
+	)
+
+	b := &bytes.Buffer{}
+	w := csv.NewWriter(b)
+	w.WriteAll(records)
+
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
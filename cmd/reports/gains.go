@@ -0,0 +1,93 @@
+package reports
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Gains report of the capital gains of each tax year, split by term
+// as US filers need. Takes a lot method, for the acquisition dates.
+type Gains struct {
+	Currency   string
+	Method     string
+	Selections []*models.LotSelection
+	Years      []*GainsYear
+}
+
+// GainsYear totals the sales of a tax year by term
+type GainsYear struct {
+	Year      int
+	ShortTerm *GainsTotal
+	LongTerm  *GainsTotal
+}
+
+// GainsTotal of the sales of a term. Cost includes the selling expenses.
+type GainsTotal struct {
+	Proceeds decimal.Decimal
+	Cost     decimal.Decimal
+	Gain     decimal.Decimal
+}
+
+// LongTerm is true when held more than one year, counted
+// from the day after it was acquired
+func LongTerm(acquired, sold time.Time) bool {
+	a := acquired.UTC()
+	s := sold.UTC()
+	anniversary := time.Date(a.Year()+1, a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	return !time.Date(s.Year(), s.Month(), s.Day(), 0, 0, 0, 0, time.UTC).Before(anniversary.AddDate(0, 0, 1))
+}
+
+// lotDispositions returns the sales by lot, for the methods that keep lots,
+// and the trades against base they were taken from
+func lotDispositions(ts []*models.Trade, base, method string, sel []*models.LotSelection, c Converter) ([]*models.Trade, []*Disposition, error) {
+	if method == "" || method == AverageCost || !ValidMethod(method) {
+		return nil, nil, errors.New("Invalid cost basis method, needs lots")
+	}
+
+	trades, err := expandAgainstBase(ts, base, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, ds, err := tallyLots(trades, method, sel, time.Time{})
+	return trades, ds, err
+}
+
+// Build the report
+func (r *Gains) Build(ts []*models.Trade, c Converter) error {
+	if r.Currency == "" {
+		return errors.New("Invalid currency")
+	}
+	_, ds, err := lotDispositions(ts, r.Currency, r.Method, r.Selections, c)
+	if err != nil {
+		return err
+	}
+
+	years := make(map[int]*GainsYear)
+	for _, d := range ds {
+		y := years[d.Date.Year()]
+		if y == nil {
+			y = &GainsYear{Year: d.Date.Year(), ShortTerm: &GainsTotal{}, LongTerm: &GainsTotal{}}
+			years[y.Year] = y
+		}
+		t := y.ShortTerm
+		if d.LongTerm {
+			t = y.LongTerm
+		}
+		t.Proceeds = t.Proceeds.Add(d.Proceeds)
+		t.Cost = t.Cost.Add(d.ACB).Add(d.Expenses)
+		t.Gain = t.Gain.Add(d.Gain)
+	}
+
+	r.Years = nil
+	for _, y := range years {
+		r.Years = append(r.Years, y)
+	}
+	sort.Slice(r.Years, func(i, j int) bool {
+		return r.Years[i].Year < r.Years[j].Year
+	})
+	return nil
+}
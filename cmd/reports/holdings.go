@@ -2,22 +2,40 @@ package reports
 
 import (
 	"errors"
+	"sort"
+	"time"
 
 	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
 
 type Holdings struct {
-	Currency string
-	Items    []*HoldingItem
+	Currency     string
+	From         time.Time              // start of the period realized gains cover
+	AsOf         time.Time              // trades up to, all when zero
+	Method       string                 // cost basis method, average cost when empty
+	Selections   []*models.LotSelection // lots picked, for specific identification
+	Items        []*HoldingItem
+	Lots         []*Lot // left, except with average cost
+	Accounts     []*AccountItem
+	Dispositions []*Disposition // in the period
+	Realized     decimal.Decimal
 }
 
 type HoldingItem struct {
-	Asset  string
-	Amount decimal.Decimal
-	ACB    decimal.Decimal
-	Value  decimal.Decimal
-	Gain   decimal.Decimal
+	Asset   string
+	Amount  decimal.Decimal
+	ACB     decimal.Decimal
+	Value   decimal.Decimal // market value as of
+	Gain    decimal.Decimal // unrealized
+	Percent decimal.Decimal // unrealized gain over the ACB
+}
+
+// AccountItem is the amount of an asset held in one account
+type AccountItem struct {
+	AccountID uint
+	Asset     string
+	Amount    decimal.Decimal
 }
 
 // Build the report
@@ -31,9 +49,37 @@ func (r *Holdings) Build(ts []*models.Trade, c Converter) error {
 		return err
 	}
 
-	cost, bal, err := tally(trades)
-	if err != nil {
-		return err
+	var cost, bal map[string]decimal.Decimal
+	if r.Method == "" || r.Method == AverageCost {
+		cost, bal, r.Dispositions, err = tally(trades, r.AsOf)
+		if err != nil {
+			return err
+		}
+	} else {
+		if !ValidMethod(r.Method) {
+			return errors.New("Invalid cost basis method")
+		}
+		lots, ds, err := tallyLots(trades, r.Method, r.Selections, r.AsOf)
+		if err != nil {
+			return err
+		}
+		r.Dispositions = ds
+
+		cost = make(map[string]decimal.Decimal)
+		bal = make(map[string]decimal.Decimal)
+		for curr, ls := range lots {
+			for _, l := range ls {
+				cost[curr] = cost[curr].Add(l.Cost)
+				bal[curr] = bal[curr].Add(l.Amount)
+				r.Lots = append(r.Lots, l)
+			}
+		}
+		sort.SliceStable(r.Lots, func(i, j int) bool {
+			if r.Lots[i].Asset == r.Lots[j].Asset {
+				return r.Lots[i].Date.Before(r.Lots[j].Date)
+			}
+			return r.Lots[i].Asset < r.Lots[j].Asset
+		})
 	}
 
 	for curr := range cost {
@@ -43,6 +89,103 @@ func (r *Holdings) Build(ts []*models.Trade, c Converter) error {
 			ACB:    cost[curr],
 		})
 	}
+	if !r.AsOf.IsZero() {
+		r.value(c)
+	}
+
+	// gains realized in the period
+	var ds []*Disposition
+	r.Realized = decimal.NewFromFloat(0)
+	for _, d := range r.Dispositions {
+		if d.Date.Before(r.From) {
+			continue
+		}
+		ds = append(ds, d)
+		r.Realized = r.Realized.Add(d.Gain)
+	}
+	r.Dispositions = ds
 
 	return nil
 }
+
+// value the items at the as of date
+func (r *Holdings) value(c Converter) {
+	for _, i := range r.Items {
+		i.Value = c.Convert(i.Amount, i.Asset, r.Currency, r.AsOf)
+		i.Gain = i.Value.Sub(i.ACB)
+		if !i.ACB.IsZero() {
+			i.Percent = i.Gain.Div(i.ACB)
+		}
+	}
+}
+
+// BuildAccounts breaks the amounts held down per account. Transfers
+// entered without imported trades move amounts between accounts.
+// Fiat in the report currency isn't tracked, its deposits aren't imported.
+func (r *Holdings) BuildAccounts(ts []*models.Trade, trs []*models.Transfer) {
+	bal := make(map[uint]map[string]decimal.Decimal)
+	add := func(aid uint, asset string, amount decimal.Decimal) {
+		if aid == 0 || asset == r.Currency || amount.IsZero() {
+			return
+		}
+		if bal[aid] == nil {
+			bal[aid] = make(map[string]decimal.Decimal)
+		}
+		bal[aid][asset] = bal[aid][asset].Add(amount)
+	}
+
+	for _, t := range ts {
+		if !r.AsOf.IsZero() && t.Date.After(r.AsOf) {
+			continue
+		}
+		switch t.Action {
+		case "BUY":
+			add(t.AccountID, t.Currency, t.Amount)
+			add(t.AccountID, t.BaseCurrency, t.BaseAmount.Neg())
+		case "SELL":
+			add(t.AccountID, t.Currency, t.Amount.Neg())
+			add(t.AccountID, t.BaseCurrency, t.BaseAmount)
+		case "SEND":
+			add(t.AccountID, t.Currency, t.Amount.Neg())
+		case "RECEIVE":
+			add(t.AccountID, t.Currency, t.Amount)
+		default:
+			if !models.IsIncome(t.Action) {
+				continue
+			}
+			add(t.AccountID, t.Currency, t.Amount)
+		}
+		add(t.AccountID, t.FeeCurrency, t.FeeAmount.Neg())
+	}
+
+	for _, t := range trs {
+		if !r.AsOf.IsZero() && t.Date.After(r.AsOf) {
+			continue
+		}
+		if t.WithdrawalID == 0 && t.DepositID == 0 {
+			add(t.FromAccountID, t.Asset, t.Amount.Add(t.FeeAmount).Neg())
+			add(t.ToAccountID, t.Asset, t.Amount)
+		}
+	}
+
+	r.Accounts = nil
+	for aid, assets := range bal {
+		for asset, amount := range assets {
+			if amount.IsZero() {
+				continue
+			}
+			r.Accounts = append(r.Accounts, &AccountItem{
+				AccountID: aid,
+				Asset:     asset,
+				Amount:    amount,
+			})
+		}
+	}
+	sort.Slice(r.Accounts, func(i, j int) bool {
+		a, b := r.Accounts[i], r.Accounts[j]
+		if a.AccountID == b.AccountID {
+			return a.Asset < b.Asset
+		}
+		return a.AccountID < b.AccountID
+	})
+}
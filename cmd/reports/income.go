@@ -0,0 +1,66 @@
+package reports
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Income report of assets received as income, valued when received
+type Income struct {
+	Currency string
+	From     time.Time
+	To       time.Time
+	Items    []*IncomeItem
+	Totals   map[string]decimal.Decimal // by kind of income
+	Total    decimal.Decimal
+}
+
+// IncomeItem is one income event
+type IncomeItem struct {
+	Date   time.Time
+	Kind   string
+	Asset  string
+	Amount decimal.Decimal
+	Value  decimal.Decimal
+}
+
+// Build the report from the income received between From and To,
+// all of it when they aren't set
+func (r *Income) Build(ts []*models.Trade, c Converter) error {
+	if r.Currency == "" {
+		return errors.New("Invalid currency")
+	}
+
+	r.Items = nil
+	r.Totals = make(map[string]decimal.Decimal)
+	r.Total = decimal.NewFromFloat(0)
+
+	for _, t := range ts {
+		if !models.IsIncome(t.Action) {
+			continue
+		}
+		if (!r.From.IsZero() && t.Date.Before(r.From)) || (!r.To.IsZero() && t.Date.After(r.To)) {
+			continue
+		}
+
+		v := incomeValue(t, r.Currency, c)
+		r.Items = append(r.Items, &IncomeItem{
+			Date:   t.Date,
+			Kind:   t.Action,
+			Asset:  t.Currency,
+			Amount: t.Amount,
+			Value:  v,
+		})
+		r.Totals[t.Action] = r.Totals[t.Action].Add(v)
+		r.Total = r.Total.Add(v)
+	}
+
+	sort.SliceStable(r.Items, func(i, j int) bool {
+		return r.Items[i].Date.Before(r.Items[j].Date)
+	})
+	return nil
+}
@@ -0,0 +1,164 @@
+package reports
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Cost basis methods
+const (
+	AverageCost = "ACB"      // pooled, as Canada requires
+	FIFO        = "FIFO"     // first in, first out
+	LIFO        = "LIFO"     // last in, first out
+	HIFO        = "HIFO"     // highest cost first
+	SpecificID  = "SPECIFIC" // lots picked for each sale, FIFO for the rest
+)
+
+// ValidMethod checks the cost basis method, empty is average cost
+func ValidMethod(m string) bool {
+	switch m {
+	case "", AverageCost, FIFO, LIFO, HIFO, SpecificID:
+		return true
+	}
+	return false
+}
+
+// Lot is what's left of an acquisition of an asset
+type Lot struct {
+	TradeID uint
+	Date    time.Time
+	Asset   string
+	Amount  decimal.Decimal
+	Cost    decimal.Decimal // of the amount left
+}
+
+// unitCost of the lot, zero when it holds nothing
+func (l *Lot) unitCost() decimal.Decimal {
+	if l.Amount.IsZero() {
+		return decimal.Decimal{}
+	}
+	return l.Cost.Div(l.Amount)
+}
+
+// tallyLots keeps each acquisition as a lot and takes sales from them in
+// the order of the method, up to asOf when set. Returns the lots left by
+// asset, and the gain or loss of each part of a sale.
+func tallyLots(ts []*models.Trade, method string, sel []*models.LotSelection, asOf time.Time) (map[string][]*Lot, []*Disposition, error) {
+	sort.Stable(byDate(ts))
+
+	lots := make(map[string][]*Lot)
+	oversold := make(map[string]decimal.Decimal)
+	var ds []*Disposition
+
+	for _, t := range ts {
+		if !asOf.IsZero() && t.Date.After(asOf) {
+			break
+		}
+		if t.Action == "BUY" {
+			lots[t.Currency] = append(lots[t.Currency], &Lot{
+				TradeID: t.ID,
+				Date:    t.Date,
+				Asset:   t.Currency,
+				Amount:  t.Amount,
+				Cost:    t.BaseAmount.Add(t.FeeAmount),
+			})
+		}
+		if (t.Action != "SELL" && t.Action != "FEE") || !t.Amount.IsPositive() {
+			continue
+		}
+
+		var held decimal.Decimal
+		for _, l := range lots[t.Currency] {
+			held = held.Add(l.Amount)
+		}
+		if held.LessThan(t.Amount) {
+			oversold[t.Currency] = oversold[t.Currency].Add(t.Amount.Sub(held))
+			continue
+		}
+
+		// take from a lot, its share of the proceeds and expenses
+		left := t.Amount
+		take := func(l *Lot, n decimal.Decimal) {
+			if n.GreaterThan(left) {
+				n = left
+			}
+			if n.GreaterThan(l.Amount) {
+				n = l.Amount
+			}
+			if !n.IsPositive() {
+				return
+			}
+			cost := l.Cost.Mul(n).Div(l.Amount)
+			share := n.Div(t.Amount)
+			d := &Disposition{
+				SaleID:   t.ID,
+				LotID:    l.TradeID,
+				Acquired: l.Date,
+				LongTerm: LongTerm(l.Date, t.Date),
+				Date:     t.Date,
+				Asset:    t.Currency,
+				Amount:   n,
+				Proceeds: t.BaseAmount.Mul(share),
+				ACB:      cost,
+				Expenses: t.FeeAmount.Mul(share),
+			}
+			d.Gain = d.Proceeds.Sub(d.ACB).Sub(d.Expenses)
+			ds = append(ds, d)
+
+			l.Amount = l.Amount.Sub(n)
+			l.Cost = l.Cost.Sub(cost)
+			left = left.Sub(n)
+		}
+
+		if method == SpecificID {
+			for _, s := range sel {
+				if s.SaleID != t.ID || s.Asset != t.Currency {
+					continue
+				}
+				for _, l := range lots[t.Currency] {
+					if l.TradeID == s.LotID {
+						take(l, s.Amount)
+					}
+				}
+			}
+		}
+		for _, l := range ordered(lots[t.Currency], method) {
+			take(l, left)
+		}
+
+		// drop the lots used up
+		var open []*Lot
+		for _, l := range lots[t.Currency] {
+			if l.Amount.IsPositive() {
+				open = append(open, l)
+			}
+		}
+		lots[t.Currency] = open
+	}
+
+	if len(oversold) > 0 {
+		return nil, nil, &Oversold{oversold}
+	}
+	return lots, ds, nil
+}
+
+// ordered returns the lots in the order a sale takes from them,
+// lots are kept in the order acquired
+func ordered(ls []*Lot, method string) []*Lot {
+	o := make([]*Lot, len(ls))
+	copy(o, ls)
+	switch method {
+	case LIFO:
+		for i, j := 0, len(o)-1; i < j; i, j = i+1, j-1 {
+			o[i], o[j] = o[j], o[i]
+		}
+	case HIFO:
+		sort.SliceStable(o, func(i, j int) bool {
+			return o[i].unitCost().GreaterThan(o[j].unitCost())
+		})
+	}
+	return o
+}
@@ -0,0 +1,55 @@
+package reports
+
+import (
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// Observed wraps the converter to value an asset at the price of the
+// user's own trade of it against the currency closest to the date, within
+// the window on either side. Falls back to the converter without one.
+func Observed(ts []*models.Trade, window time.Duration, c Converter) Converter {
+	// trades by the pair they price, both ways
+	pairs := make(map[string][]*models.Trade)
+	for _, t := range ts {
+		if t.Action != "BUY" && t.Action != "SELL" {
+			continue
+		}
+		if t.Amount.IsZero() || t.BaseAmount.IsZero() || t.Currency == t.BaseCurrency {
+			continue
+		}
+		pairs[t.Currency+t.BaseCurrency] = append(pairs[t.Currency+t.BaseCurrency], t)
+		pairs[t.BaseCurrency+t.Currency] = append(pairs[t.BaseCurrency+t.Currency], t)
+	}
+
+	return Converter{
+		Convert: func(amount decimal.Decimal, from, to string, on time.Time) decimal.Decimal {
+			if from == to {
+				return amount
+			}
+
+			var closest *models.Trade
+			var gap time.Duration
+			for _, t := range pairs[from+to] {
+				d := t.Date.Sub(on)
+				if d < 0 {
+					d = -d
+				}
+				if d <= window && (closest == nil || d < gap) {
+					closest, gap = t, d
+				}
+			}
+			if closest == nil {
+				return c.Convert(amount, from, to, on)
+			}
+
+			// the price of from in to
+			if closest.Currency == from {
+				return amount.Mul(closest.BaseAmount).Div(closest.Amount)
+			}
+			return amount.Mul(closest.Amount).Div(closest.BaseAmount)
+		},
+	}
+}
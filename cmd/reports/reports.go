@@ -46,6 +46,23 @@ type Rate struct {
 	Rate     string `json:"rate"`
 }
 
+// Disposition is a sale of an asset and its gain or loss. With lots,
+// there is one per lot the sale was taken from.
+type Disposition struct {
+	SaleID      uint
+	LotID       uint      // lots only
+	Acquired    time.Time // with average cost, when the pool was started
+	LongTerm    bool      // lots only, held more than a year
+	Date        time.Time
+	Asset       string
+	Amount      decimal.Decimal
+	Proceeds    decimal.Decimal
+	ACB         decimal.Decimal // of the units sold
+	Expenses    decimal.Decimal
+	Gain        decimal.Decimal // negative for a loss, without the denied part
+	Superficial decimal.Decimal // loss denied, added to the cost of the units bought back
+}
+
 // Trades are sortable by date
 type byDate []*models.Trade
 
@@ -68,7 +85,16 @@ func Analyze(ts []*models.Trade, base string) (rrs []*RateRequest, err error) {
 	rmap := make(map[int64][]*Rate, 0)
 
 	for _, t := range ts {
-		if t.BaseCurrency != base {
+		// events other than trades and income don't change the cost
+		if t.Action != "BUY" && t.Action != "SELL" && t.Action != "FEE" && !models.IsIncome(t.Action) {
+			continue
+		}
+		if fmvNeeded(t) && t.Currency != base {
+			d := t.Date.Unix()
+			if !includes(rmap[d], t.Currency) {
+				rmap[d] = append(rmap[d], &Rate{Currency: t.Currency})
+			}
+		} else if t.BaseCurrency != base {
 			d := t.Date.Unix()
 			if !includes(rmap[d], t.BaseCurrency) {
 				rmap[d] = append(rmap[d], &Rate{Currency: t.BaseCurrency})
@@ -92,6 +118,82 @@ func Analyze(ts []*models.Trade, base string) (rrs []*RateRequest, err error) {
 	return
 }
 
+// Valuation returns the rates needed to value what's held at the date
+func Valuation(ts []*models.Trade, base string, on time.Time) *RateRequest {
+	rr := &RateRequest{Timestamp: on.Unix()}
+	for _, t := range ts {
+		if t.Date.After(on) {
+			continue
+		}
+		for _, c := range []string{t.Currency, t.BaseCurrency} {
+			if c != base && !includes(rr.Rates, c) {
+				rr.Rates = append(rr.Rates, &Rate{Currency: c})
+			}
+		}
+	}
+	if len(rr.Rates) == 0 {
+		return nil
+	}
+	return rr
+}
+
+// fmvNeeded is true for income received without its value
+func fmvNeeded(t *models.Trade) bool {
+	return models.IsIncome(t.Action) && t.BaseAmount.IsZero()
+}
+
+// incomeValue is the fair market value of income when received,
+// as given by the exchange or else from the rate of the asset
+func incomeValue(t *models.Trade, base string, c Converter) decimal.Decimal {
+	if fmvNeeded(t) {
+		return c.Convert(t.Amount, t.Currency, base, t.Date)
+	}
+	return c.Convert(t.BaseAmount, t.BaseCurrency, base, t.Date)
+}
+
+// TransferFees returns the network fees of transfers as trades, paying
+// one is a disposition of the asset at its value
+func TransferFees(trs []*models.Transfer) (ts []*models.Trade) {
+	for _, t := range trs {
+		if !t.FeeAmount.IsPositive() {
+			continue
+		}
+		ts = append(ts, &models.Trade{
+			Date:         t.Date,
+			Action:       "FEE",
+			Amount:       t.FeeAmount,
+			Currency:     t.Asset,
+			BaseAmount:   t.FeeAmount,
+			BaseCurrency: t.Asset,
+			FeeAmount:    decimal.NewFromFloat(0),
+			FeeCurrency:  t.Asset,
+			UserID:       t.UserID,
+		})
+	}
+	return
+}
+
+// Unmatched returns the coins sent and received up to asOf, when set, that
+// no transfer matches. They aren't in the cost of what's held: a coin
+// received has no known cost, and one sent may be a payment or a gift.
+func Unmatched(ts []*models.Trade, trs []*models.Transfer, asOf time.Time) (us []*models.Trade) {
+	matched := make(map[uint]bool)
+	for _, tr := range trs {
+		matched[tr.WithdrawalID] = true
+		matched[tr.DepositID] = true
+	}
+	for _, t := range ts {
+		if t.Action != "SEND" && t.Action != "RECEIVE" {
+			continue
+		}
+		if matched[t.ID] || (!asOf.IsZero() && t.Date.After(asOf)) {
+			continue
+		}
+		us = append(us, t)
+	}
+	return
+}
+
 func includes(rs []*Rate, c string) bool {
 	for _, r := range rs {
 		if r.Currency == c {
@@ -101,7 +203,9 @@ func includes(rs []*Rate, c string) bool {
 	return false
 }
 
-// add extra trades so all are against base currency
+// add extra trades so all are against base currency, each keeps
+// the id of the trade it came from. Fees paid in an asset are FEE
+// disposals of it.
 func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*models.Trade, err error) {
 	for _, t := range ts {
 		fa := c.Convert(t.FeeAmount, t.FeeCurrency, base, t.Date)
@@ -109,6 +213,7 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 
 		if t.Action == "BUY" {
 			rts = append(rts, &models.Trade{
+				ID:           t.ID,
 				Date:         t.Date,
 				Action:       "BUY",
 				Amount:       t.Amount,
@@ -122,6 +227,7 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 			if t.BaseCurrency != base {
 				// cross pair, need an extra sell
 				rts = append(rts, &models.Trade{
+					ID:           t.ID,
 					Date:         t.Date,
 					Action:       "SELL",
 					Amount:       t.BaseAmount,
@@ -133,10 +239,38 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 				})
 			}
 			if t.FeeCurrency != base {
-				// cross pair, need an extra sell
+				// paying the fee is a disposition of it
 				rts = append(rts, &models.Trade{
+					ID:           t.ID,
 					Date:         t.Date,
-					Action:       "SELL",
+					Action:       "FEE",
+					Amount:       t.FeeAmount,
+					Currency:     t.FeeCurrency,
+					BaseAmount:   fa,
+					BaseCurrency: base,
+					FeeAmount:    decimal.NewFromFloat(0),
+					FeeCurrency:  base,
+				})
+			}
+		} else if models.IsIncome(t.Action) {
+			// acquired at its value, nothing given up for it
+			rts = append(rts, &models.Trade{
+				ID:           t.ID,
+				Date:         t.Date,
+				Action:       "BUY",
+				Amount:       t.Amount,
+				Currency:     t.Currency,
+				BaseAmount:   incomeValue(t, base, c),
+				BaseCurrency: base,
+				FeeAmount:    fa,
+				FeeCurrency:  base,
+			})
+			if t.FeeCurrency != base && !t.FeeAmount.IsZero() {
+				// paying the fee is a disposition of it
+				rts = append(rts, &models.Trade{
+					ID:           t.ID,
+					Date:         t.Date,
+					Action:       "FEE",
 					Amount:       t.FeeAmount,
 					Currency:     t.FeeCurrency,
 					BaseAmount:   fa,
@@ -145,8 +279,22 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 					FeeCurrency:  base,
 				})
 			}
+		} else if t.Action == "FEE" {
+			// proceeds are the value of the asset spent
+			rts = append(rts, &models.Trade{
+				ID:           t.ID,
+				Date:         t.Date,
+				Action:       "FEE",
+				Amount:       t.Amount,
+				Currency:     t.Currency,
+				BaseAmount:   ba,
+				BaseCurrency: base,
+				FeeAmount:    decimal.NewFromFloat(0),
+				FeeCurrency:  base,
+			})
 		} else if t.Action == "SELL" {
 			rts = append(rts, &models.Trade{
+				ID:           t.ID,
 				Date:         t.Date,
 				Action:       "SELL",
 				Amount:       t.Amount,
@@ -160,6 +308,7 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 			if t.BaseCurrency != base {
 				// cross pair, need an extra buy
 				rts = append(rts, &models.Trade{
+					ID:           t.ID,
 					Date:         t.Date,
 					Action:       "BUY",
 					Amount:       t.BaseAmount,
@@ -172,10 +321,11 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 			}
 
 			if t.FeeCurrency != base {
-				// cross pair, need an extra sell
+				// paying the fee is a disposition of it
 				rts = append(rts, &models.Trade{
+					ID:           t.ID,
 					Date:         t.Date,
-					Action:       "SELL",
+					Action:       "FEE",
 					Amount:       t.FeeAmount,
 					Currency:     t.FeeCurrency,
 					BaseAmount:   fa,
@@ -190,31 +340,99 @@ func expandAgainstBase(ts []*models.Trade, base string, c Converter) (rts []*mod
 	return
 }
 
-func tally(ts []*models.Trade) (map[string]decimal.Decimal, map[string]decimal.Decimal, error) {
+// ParseAsOf reads the date a report is as of: Today, a tax year like 2018
+// or a date like 2018-06-30. Returns the start of its calendar year and
+// the end of the day, in UTC.
+func ParseAsOf(s string, now time.Time) (from, to time.Time, err error) {
+	if s == "Today" {
+		s = now.UTC().Format("2006-01-02")
+	}
+	switch {
+	case len(s) == 4:
+		var y time.Time
+		if y, err = time.Parse("2006", s); err != nil {
+			return
+		}
+		to = y.AddDate(1, 0, 0).Add(-time.Nanosecond)
+	default:
+		var d time.Time
+		if d, err = time.Parse("2006-01-02", s); err != nil {
+			return
+		}
+		to = d.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	from = time.Date(to.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	return
+}
+
+// tally the cost and balance of each asset, and the gain or loss of each
+// sale, up to asOf when set. Superficial losses are denied and added to the
+// cost of the asset, once it's held again when the sale left none. The
+// trades after asOf can still deny a loss before it.
+func tally(ts []*models.Trade, asOf time.Time) (map[string]decimal.Decimal, map[string]decimal.Decimal, []*Disposition, error) {
 	sort.Sort(byDate(ts))
 
 	cost := make(map[string]decimal.Decimal)
 	bal := make(map[string]decimal.Decimal)
+	denied := make(map[string]decimal.Decimal)
+	started := make(map[string]time.Time)
 	oversold := make(map[string]decimal.Decimal)
+	var ds []*Disposition
 
 	for _, t := range ts {
+		if !asOf.IsZero() && t.Date.After(asOf) {
+			break
+		}
 		if t.Action == "BUY" {
-			cost[t.Currency] = cost[t.Currency].Add(t.BaseAmount).Add(t.FeeAmount)
+			if !bal[t.Currency].IsPositive() {
+				started[t.Currency] = t.Date
+			}
+			cost[t.Currency] = cost[t.Currency].Add(t.BaseAmount).Add(t.FeeAmount).Add(denied[t.Currency])
 			bal[t.Currency] = bal[t.Currency].Add(t.Amount)
+			delete(denied, t.Currency)
 		}
-		if t.Action == "SELL" {
+		if t.Action == "SELL" || t.Action == "FEE" {
 			newb := bal[t.Currency].Sub(t.Amount)
 			if newb.IsNegative() {
 				oversold[t.Currency] = oversold[t.Currency].Sub(newb)
 				continue
 			}
-			cost[t.Currency] = cost[t.Currency].Div(bal[t.Currency]).Mul(newb)
+			// nothing held, like a sale of nothing, has no cost left
+			var left decimal.Decimal
+			if !bal[t.Currency].IsZero() {
+				left = cost[t.Currency].Div(bal[t.Currency]).Mul(newb)
+			}
+
+			d := &Disposition{
+				SaleID:   t.ID,
+				Acquired: started[t.Currency],
+				Date:     t.Date,
+				Asset:    t.Currency,
+				Amount:   t.Amount,
+				Proceeds: t.BaseAmount,
+				ACB:      cost[t.Currency].Sub(left),
+				Expenses: t.FeeAmount,
+			}
+			d.Gain = d.Proceeds.Sub(d.ACB).Sub(d.Expenses)
+			// paying a fee isn't a sale the asset could be bought back from
+			if t.Action == "SELL" {
+				d.Superficial = DeniedLoss(ts, t, d.Gain.Neg())
+				d.Gain = d.Gain.Add(d.Superficial)
+			}
+			ds = append(ds, d)
+
+			if newb.IsPositive() {
+				left = left.Add(d.Superficial)
+			} else {
+				denied[t.Currency] = denied[t.Currency].Add(d.Superficial)
+			}
+			cost[t.Currency] = left
 			bal[t.Currency] = newb
 		}
 	}
 
 	if len(oversold) > 0 {
-		return nil, nil, &Oversold{oversold}
+		return nil, nil, nil, &Oversold{oversold}
 	}
-	return cost, bal, nil
+	return cost, bal, ds, nil
 }
@@ -1,6 +1,7 @@
 package reports
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -30,7 +31,8 @@ var trades = []*models.Trade{
 		FeeAmount:    decimal.NewFromFloat(10),
 		FeeCurrency:  "BBB",
 	},
-	// AAA: bal = 99, cost = 1991.99 * 99/199 = 990.99
+	// AAA: bal = 99, cost = 1991.99 * 99/199 = 990.99, the loss of 1001 - 200 = 801
+	//      is superficial for the 99 bought back and held: cost = 990.99 + 792.99 = 1783.98
 	// BBB: bal = 90, cost = (100 * 2 + 10 * 2) * 90/100 = 198.00
 	&models.Trade{
 		Date:         time.Now().AddDate(0, 0, -8),
@@ -42,7 +44,7 @@ var trades = []*models.Trade{
 		FeeAmount:    decimal.NewFromFloat(10),
 		FeeCurrency:  "BBB",
 	},
-	// AAA: bal = 199, cost = 990.99 + 100 * 2 = 1190.99
+	// AAA: bal = 199, cost = 1783.98 + 100 * 2 = 1983.98
 	// BBB: bal = 30, cost = 198.00 * 40/90 = 88.00, 88.00 * 30/40 = 66.00
 	&models.Trade{
 		Date:         time.Now().AddDate(0, 0, -8),
@@ -54,7 +56,7 @@ var trades = []*models.Trade{
 		FeeAmount:    decimal.NewFromFloat(10),
 		FeeCurrency:  "CAD",
 	},
-	// AAA: bal = 99, cost = 1190.99 * 99/199 = 592.5025628
+	// AAA: bal = 99, cost = 1983.98 * 99/199 = 987.0051256
 	// BBB: bal = 30, cost = 66.00
 }
 
@@ -86,7 +88,7 @@ func TestBuildHoldings(t *testing.T) {
 	exp1 := &HoldingItem{
 		Asset:  "AAA",
 		Amount: decimal.NewFromFloat(99),
-		ACB:    decimal.NewFromFloat(592.502563),
+		ACB:    decimal.NewFromFloat(987.005126),
 		Value:  decimal.NewFromFloat(0),
 		Gain:   decimal.NewFromFloat(0),
 	}
@@ -145,15 +147,658 @@ func TestBuildHoldings(t *testing.T) {
 
 }
 
+func TestTransferFees(t *testing.T) {
+	trs := []*models.Transfer{
+		// moved without a fee, nothing disposed
+		&models.Transfer{
+			Date:      time.Now().AddDate(0, 0, -7),
+			Asset:     "BBB",
+			Amount:    decimal.NewFromFloat(30),
+			FeeAmount: decimal.NewFromFloat(0),
+		},
+		// AAA: bal = 98, only 98 are held after the superficial loss, 784.98 of
+		// it is denied: cost = (990.99 + 784.98 + 200) * 99/199 * 98/99 = 973.0907538
+		&models.Transfer{
+			Date:      time.Now().AddDate(0, 0, -7),
+			Asset:     "AAA",
+			Amount:    decimal.NewFromFloat(50),
+			FeeAmount: decimal.NewFromFloat(1),
+		},
+	}
+
+	fs := TransferFees(trs)
+	if len(fs) != 1 {
+		t.Fatalf("Should have 1 fee, not %v.", len(fs))
+	}
+
+	r := &Holdings{Currency: "CAD"}
+	if err := r.Build(append(trades, fs...), c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	for _, i := range r.Items {
+		if i.Asset == "AAA" && (!theSame(i.Amount, decimal.NewFromFloat(98)) || !theSame(i.ACB, decimal.NewFromFloat(973.090754))) {
+			t.Errorf("Fee should be disposed. Got: %v, %v", i.Amount, i.ACB)
+		}
+		if i.Asset == "BBB" && !theSame(i.Amount, decimal.NewFromFloat(30)) {
+			t.Errorf("Transfer should not change holdings. Got: %v", i.Amount)
+		}
+	}
+}
+
+func TestBuildAccounts(t *testing.T) {
+	d := time.Now().AddDate(0, 0, -10)
+	zero := decimal.NewFromFloat(0)
+	ts := []*models.Trade{
+		{AccountID: 1, Date: d, Action: "BUY", Amount: decimal.NewFromFloat(2), Currency: "ETH", BaseAmount: decimal.NewFromFloat(1000), BaseCurrency: "CAD", FeeAmount: decimal.NewFromFloat(5), FeeCurrency: "CAD"},
+		{AccountID: 1, Date: d, Action: "BUY", Amount: decimal.NewFromFloat(10), Currency: "BNB", BaseAmount: decimal.NewFromFloat(0.5), BaseCurrency: "ETH", FeeAmount: decimal.NewFromFloat(0.01), FeeCurrency: "BNB"},
+		{AccountID: 1, Date: d, Action: "SEND", Amount: decimal.NewFromFloat(1), Currency: "ETH", FeeAmount: decimal.NewFromFloat(0.01), FeeCurrency: "ETH"},
+		{AccountID: 2, Date: d, Action: "RECEIVE", Amount: decimal.NewFromFloat(1), Currency: "ETH", FeeAmount: zero, FeeCurrency: "ETH"},
+	}
+	trs := []*models.Transfer{
+		// matched to the trades above, already counted
+		{FromAccountID: 1, ToAccountID: 2, Asset: "ETH", Amount: decimal.NewFromFloat(1), FeeAmount: decimal.NewFromFloat(0.01), WithdrawalID: 3, DepositID: 4},
+		// entered by hand
+		{FromAccountID: 2, ToAccountID: 3, Asset: "ETH", Amount: decimal.NewFromFloat(0.5), FeeAmount: decimal.NewFromFloat(0.001)},
+	}
+
+	r := &Holdings{Currency: "CAD"}
+	r.BuildAccounts(ts, trs)
+
+	exp := []*AccountItem{
+		{AccountID: 1, Asset: "BNB", Amount: decimal.NewFromFloat(9.99)},
+		{AccountID: 1, Asset: "ETH", Amount: decimal.NewFromFloat(0.49)},
+		{AccountID: 2, Asset: "ETH", Amount: decimal.NewFromFloat(0.499)},
+		{AccountID: 3, Asset: "ETH", Amount: decimal.NewFromFloat(0.5)},
+	}
+	if len(r.Accounts) != len(exp) {
+		t.Fatalf("Should have %v items, not %v.", len(exp), len(r.Accounts))
+	}
+	for i, e := range exp {
+		a := r.Accounts[i]
+		if a.AccountID != e.AccountID || a.Asset != e.Asset || !theSame(a.Amount, e.Amount) {
+			t.Errorf("Wrong account item. Got: %+v, want: %+v", a, e)
+		}
+	}
+}
+
+func TestUnmatched(t *testing.T) {
+	d := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	one := decimal.NewFromFloat(1)
+	ts := []*models.Trade{
+		{ID: 1, Date: d, Action: "BUY", Amount: one, Currency: "ETH"},
+		{ID: 2, Date: d, Action: "SEND", Amount: one, Currency: "ETH"},
+		{ID: 3, Date: d, Action: "RECEIVE", Amount: one, Currency: "ETH"},
+		{ID: 4, Date: d, Action: "RECEIVE", Amount: one, Currency: "BTC"},
+		{ID: 5, Date: d.AddDate(0, 0, 2), Action: "SEND", Amount: one, Currency: "BTC"},
+	}
+	trs := []*models.Transfer{
+		{WithdrawalID: 2, DepositID: 3},
+	}
+
+	us := Unmatched(ts, trs, d.AddDate(0, 0, 1))
+	if len(us) != 1 || us[0].ID != 4 {
+		t.Errorf("Should only have the BTC received. Got: %+v", us)
+	}
+	if us = Unmatched(ts, trs, time.Time{}); len(us) != 2 || us[1].ID != 5 {
+		t.Errorf("Should have the BTC received and sent. Got: %+v", us)
+	}
+}
+
+func TestBuildIncome(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	ts := []*models.Trade{
+		// valued at the rate of the asset
+		{Date: time.Now().AddDate(0, 0, -5), Action: "STAKING", Amount: decimal.NewFromFloat(10), Currency: "AAA", BaseAmount: zero, BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+		// valued by the exchange
+		{Date: time.Now().AddDate(0, 0, -6), Action: "MINING", Amount: decimal.NewFromFloat(1), Currency: "BBB", BaseAmount: decimal.NewFromFloat(5), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+	}
+
+	r := &Income{Currency: "CAD"}
+	if err := r.Build(append(trades, ts...), c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if len(r.Items) != 2 {
+		t.Fatalf("Should have 2 items, not %v.", len(r.Items))
+	}
+	if r.Items[0].Kind != "MINING" || !theSame(r.Items[0].Value, decimal.NewFromFloat(5)) {
+		t.Errorf("Wrong first item. Got: %+v", r.Items[0])
+	}
+	if r.Items[1].Kind != "STAKING" || !theSame(r.Items[1].Value, decimal.NewFromFloat(20)) {
+		t.Errorf("Wrong second item. Got: %+v", r.Items[1])
+	}
+	if !theSame(r.Total, decimal.NewFromFloat(25)) {
+		t.Errorf("Total should be 25. Got: %v", r.Total)
+	}
+
+	// income is acquired at its value
+	h := &Holdings{Currency: "CAD"}
+	if err := h.Build(append(trades, ts...), c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	for _, i := range h.Items {
+		// AAA: bal = 109, all 100 bought back are held after the superficial
+		// loss, 801 is denied: cost = (990.99 + 801 + 200) * 99/199 + 20 = 1010.99
+		if i.Asset == "AAA" && (!theSame(i.Amount, decimal.NewFromFloat(109)) || !theSame(i.ACB, decimal.NewFromFloat(1010.99))) {
+			t.Errorf("Staking should add to AAA. Got: %v, %v", i.Amount, i.ACB)
+		}
+	}
+}
+
+func TestSuperficialLoss(t *testing.T) {
+	d := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	zero := decimal.NewFromFloat(0)
+	trade := func(days int, action string, amount float64, curr string, base float64) *models.Trade {
+		return &models.Trade{Date: d.AddDate(0, 0, days), Action: action, Amount: decimal.NewFromFloat(amount), Currency: curr, BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"}
+	}
+	ts := []*models.Trade{
+		// half bought back and held, half the loss denied
+		trade(-60, "BUY", 10, "AAA", 1000),
+		trade(0, "SELL", 10, "AAA", 600),
+		trade(20, "BUY", 5, "AAA", 300),
+		// none bought back
+		trade(-60, "BUY", 10, "BBB", 100),
+		trade(0, "SELL", 4, "BBB", 20),
+		// bought back but not held at the end
+		trade(-60, "BUY", 2, "CCC", 200),
+		trade(0, "SELL", 2, "CCC", 100),
+		trade(5, "BUY", 2, "CCC", 100),
+		trade(10, "SELL", 2, "CCC", 100),
+	}
+
+	r := &Holdings{Currency: "CAD"}
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+
+	exp := map[string]decimal.Decimal{
+		"AAA": decimal.NewFromFloat(200),
+		"BBB": zero,
+		"CCC": zero,
+	}
+	for _, ds := range r.Dispositions {
+		if !ds.Date.Equal(d) {
+			continue
+		}
+		if !theSame(ds.Superficial, exp[ds.Asset]) {
+			t.Errorf("%v loss denied should be %v. Got: %v", ds.Asset, exp[ds.Asset], ds.Superficial)
+		}
+	}
+	for _, i := range r.Items {
+		// denied loss goes to the units bought back
+		if i.Asset == "AAA" && !theSame(i.ACB, decimal.NewFromFloat(500)) {
+			t.Errorf("AAA ACB should be 500. Got: %v", i.ACB)
+		}
+	}
+}
+
+func TestSuperficialFeesAndCrossSales(t *testing.T) {
+	d := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	zero := decimal.NewFromFloat(0)
+	ts := []*models.Trade{
+		// the fee paid in the asset bought is a loss, but not a sale
+		{Date: d, Action: "BUY", Amount: decimal.NewFromFloat(10), Currency: "DDD", BaseAmount: decimal.NewFromFloat(1000), BaseCurrency: "CAD", FeeAmount: decimal.NewFromFloat(1), FeeCurrency: "DDD"},
+		// EEE sold for FFF at a loss of 980, and bought back the next day
+		{Date: d.AddDate(0, 0, -60), Action: "BUY", Amount: decimal.NewFromFloat(10), Currency: "EEE", BaseAmount: decimal.NewFromFloat(1000), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+		{Date: d, Action: "BUY", Amount: decimal.NewFromFloat(5), Currency: "FFF", BaseAmount: decimal.NewFromFloat(10), BaseCurrency: "EEE", FeeAmount: zero, FeeCurrency: "CAD"},
+		{Date: d.AddDate(0, 0, 1), Action: "SELL", Amount: decimal.NewFromFloat(5), Currency: "FFF", BaseAmount: decimal.NewFromFloat(10), BaseCurrency: "EEE", FeeAmount: zero, FeeCurrency: "CAD"},
+	}
+
+	r := &Holdings{Currency: "CAD"}
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+
+	exp := map[string]decimal.Decimal{
+		"DDD": zero,
+		"EEE": decimal.NewFromFloat(980),
+		"FFF": zero,
+	}
+	for _, ds := range r.Dispositions {
+		if !theSame(ds.Superficial, exp[ds.Asset]) {
+			t.Errorf("%v loss denied should be %v. Got: %v", ds.Asset, exp[ds.Asset], ds.Superficial)
+		}
+	}
+	for _, i := range r.Items {
+		// DDD: cost = (1000 + 2) * 9/10, EEE: cost = 20 + 980 denied
+		if i.Asset == "DDD" && !theSame(i.ACB, decimal.NewFromFloat(901.8)) {
+			t.Errorf("DDD ACB should be 901.8. Got: %v", i.ACB)
+		}
+		if i.Asset == "EEE" && !theSame(i.ACB, decimal.NewFromFloat(1000)) {
+			t.Errorf("EEE ACB should be 1000. Got: %v", i.ACB)
+		}
+	}
+}
+
+func TestTallyEmptySale(t *testing.T) {
+	d := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	zero := decimal.NewFromFloat(0)
+	ts := []*models.Trade{
+		// a fee of nothing, paid in an asset not held
+		{Date: d, Action: "BUY", Amount: decimal.NewFromFloat(1), Currency: "AAA", BaseAmount: decimal.NewFromFloat(100), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "BBB"},
+	}
+
+	r := &Holdings{Currency: "CAD"}
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build with a sale of nothing. Got: %v", err)
+	}
+	for _, d := range r.Dispositions {
+		if d.Asset == "BBB" && (!d.ACB.IsZero() || !d.Gain.IsZero()) {
+			t.Errorf("Sale of nothing should have no cost or gain. Got: %+v", d)
+		}
+	}
+}
+
+func TestBuildLots(t *testing.T) {
+	d := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	zero := decimal.NewFromFloat(0)
+	trade := func(id uint, days int, action string, base float64) *models.Trade {
+		return &models.Trade{ID: id, Date: d.AddDate(0, 0, days), Action: action, Amount: decimal.NewFromFloat(1), Currency: "AAA", BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"}
+	}
+	ts := []*models.Trade{
+		trade(1, 1, "BUY", 100),
+		trade(2, 2, "BUY", 300),
+		trade(3, 3, "BUY", 200),
+		trade(4, 4, "SELL", 250),
+	}
+	sel := []*models.LotSelection{
+		{SaleID: 4, LotID: 2, Asset: "AAA", Amount: decimal.NewFromFloat(0.5)},
+	}
+
+	tests := []struct {
+		method string
+		cost   float64 // of the unit sold
+		left   float64
+	}{
+		{FIFO, 100, 500},
+		{LIFO, 200, 400},
+		{HIFO, 300, 300},
+		{SpecificID, 200, 400}, // half of lot 2, half of lot 1
+	}
+	for _, tt := range tests {
+		r := &Holdings{Currency: "CAD", Method: tt.method, Selections: sel}
+		if err := r.Build(ts, c); err != nil {
+			t.Fatalf("%v should build correctly. Got: %v", tt.method, err)
+		}
+
+		var cost, gain decimal.Decimal
+		for _, ds := range r.Dispositions {
+			cost = cost.Add(ds.ACB)
+			gain = gain.Add(ds.Gain)
+		}
+		if !theSame(cost, decimal.NewFromFloat(tt.cost)) || !theSame(gain, decimal.NewFromFloat(250-tt.cost)) {
+			t.Errorf("%v should sell at a cost of %v. Got: %v, gain %v", tt.method, tt.cost, cost, gain)
+		}
+		if len(r.Items) != 1 || !theSame(r.Items[0].Amount, decimal.NewFromFloat(2)) || !theSame(r.Items[0].ACB, decimal.NewFromFloat(tt.left)) {
+			t.Errorf("%v should leave 2 at a cost of %v. Got: %+v", tt.method, tt.left, r.Items[0])
+		}
+	}
+
+	// a lot of nothing is sorted without dividing by zero
+	empty := trade(5, 1, "BUY", 0)
+	empty.Amount = zero
+	r := &Holdings{Currency: "CAD", Method: HIFO}
+	if err := r.Build(append([]*models.Trade{empty}, ts...), c); err != nil {
+		t.Fatalf("Should build with an empty lot. Got: %v", err)
+	}
+	if len(r.Dispositions) != 1 || !theSame(r.Dispositions[0].ACB, decimal.NewFromFloat(300)) {
+		t.Errorf("Should sell from the highest cost lot. Got: %+v", r.Dispositions)
+	}
+
+	r = &Holdings{Currency: "CAD", Method: "AVG"}
+	if err := r.Build(ts, c); err == nil {
+		t.Errorf("Should require a valid method.")
+	}
+}
+
+func TestLongTerm(t *testing.T) {
+	a := time.Date(2017, 3, 15, 16, 0, 0, 0, time.UTC)
+	tests := []struct {
+		sold time.Time
+		long bool
+	}{
+		{time.Date(2017, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		{time.Date(2018, 3, 15, 23, 0, 0, 0, time.UTC), false},
+		{time.Date(2018, 3, 16, 0, 0, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		if LongTerm(a, tt.sold) != tt.long {
+			t.Errorf("Sold %v, long term should be %v.", tt.sold, tt.long)
+		}
+	}
+}
+
+func TestBuildGains(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	trade := func(id uint, date time.Time, action string, amount, base float64) *models.Trade {
+		return &models.Trade{ID: id, Date: date, Action: action, Amount: decimal.NewFromFloat(amount), Currency: "AAA", BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "USD", FeeAmount: zero, FeeCurrency: "USD"}
+	}
+	ts := []*models.Trade{
+		trade(1, time.Date(2017, 1, 10, 0, 0, 0, 0, time.UTC), "BUY", 2, 200),
+		trade(2, time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), "BUY", 2, 400),
+		// 2 long from lot 1, 1 short from lot 2
+		trade(3, time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), "SELL", 3, 900),
+		// 1 long from lot 2
+		trade(4, time.Date(2019, 1, 5, 0, 0, 0, 0, time.UTC), "SELL", 1, 150),
+	}
+
+	r := &Gains{Currency: "USD", Method: AverageCost}
+	if err := r.Build(ts, c); err == nil {
+		t.Errorf("Should require a lot method.")
+	}
+
+	r.Method = FIFO
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if len(r.Years) != 2 {
+		t.Fatalf("Should have 2 years, not %v.", len(r.Years))
+	}
+
+	exp := []struct {
+		year        int
+		short, long [3]float64 // proceeds, cost, gain
+	}{
+		{2018, [3]float64{300, 200, 100}, [3]float64{600, 200, 400}},
+		{2019, [3]float64{0, 0, 0}, [3]float64{150, 200, -50}},
+	}
+	for i, e := range exp {
+		y := r.Years[i]
+		if y.Year != e.year {
+			t.Errorf("Year should be %v. Got: %v", e.year, y.Year)
+		}
+		for _, tt := range []struct {
+			got *GainsTotal
+			exp [3]float64
+		}{{y.ShortTerm, e.short}, {y.LongTerm, e.long}} {
+			if !theSame(tt.got.Proceeds, decimal.NewFromFloat(tt.exp[0])) || !theSame(tt.got.Cost, decimal.NewFromFloat(tt.exp[1])) || !theSame(tt.got.Gain, decimal.NewFromFloat(tt.exp[2])) {
+				t.Errorf("%v totals should be %v. Got: %+v", e.year, tt.exp, tt.got)
+			}
+		}
+	}
+}
+
+func TestForm8949(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	ts := []*models.Trade{
+		{ID: 1, Date: time.Date(2017, 1, 10, 0, 0, 0, 0, time.UTC), Action: "BUY", Amount: decimal.NewFromFloat(2), Currency: "AAA", BaseAmount: decimal.NewFromFloat(200), BaseCurrency: "USD", FeeAmount: zero, FeeCurrency: "USD"},
+		{ID: 2, Date: time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), Action: "BUY", Amount: decimal.NewFromFloat(2), Currency: "AAA", BaseAmount: decimal.NewFromFloat(400), BaseCurrency: "USD", FeeAmount: zero, FeeCurrency: "USD"},
+		{ID: 3, Date: time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), Action: "SELL", Amount: decimal.NewFromFloat(3), Currency: "AAA", BaseAmount: decimal.NewFromFloat(900), BaseCurrency: "USD", FeeAmount: decimal.NewFromFloat(3), FeeCurrency: "USD"},
+		{ID: 4, Date: time.Date(2019, 1, 5, 0, 0, 0, 0, time.UTC), Action: "SELL", Amount: decimal.NewFromFloat(1), Currency: "AAA", BaseAmount: decimal.NewFromFloat(150), BaseCurrency: "USD", FeeAmount: zero, FeeCurrency: "USD"},
+	}
+
+	f := &Form8949{Currency: "USD", Method: FIFO, Year: 2018}
+	if err := f.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if len(f.ShortTerm) != 1 || len(f.LongTerm) != 1 {
+		t.Fatalf("Should have 1 row in each part. Got: %v, %v", len(f.ShortTerm), len(f.LongTerm))
+	}
+
+	// expenses split by the amount from each lot
+	exp := &Form8949Row{Description: "2 AAA", Acquired: "01/10/2017", Sold: "06/01/2018", Proceeds: decimal.NewFromFloat(600), Cost: decimal.NewFromFloat(202), Gain: decimal.NewFromFloat(398)}
+	row := f.LongTerm[0]
+	if row.Description != exp.Description || row.Acquired != exp.Acquired || row.Sold != exp.Sold ||
+		!theSame(row.Proceeds, exp.Proceeds) || !theSame(row.Cost, exp.Cost) || !theSame(row.Gain, exp.Gain) {
+		t.Errorf("Wrong long term row. Got: %+v, want: %+v", row, exp)
+	}
+
+	b, err := f.CSV()
+	if err != nil {
+		t.Fatalf("Should write the CSV. Got: %v", err)
+	}
+	for _, l := range []string{
+		"1 AAA,12/01/2017,06/01/2018,300.00,201.00,,,99.00",
+		"Totals,,,600.00,202.00,,,398.00",
+		"3,300.00,201.00,0.00,99.00",
+		"10,600.00,202.00,0.00,398.00",
+		"16,,,,497.00",
+	} {
+		if !strings.Contains(string(b), l+"\n") {
+			t.Errorf("CSV should have the line %v. Got:\n%s", l, b)
+		}
+	}
+}
+
+func TestForm8949WashSale(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	trade := func(id uint, date time.Time, action string, amount, base float64) *models.Trade {
+		return &models.Trade{ID: id, Date: date, Action: action, Amount: decimal.NewFromFloat(amount), Currency: "AAA", BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "USD", FeeAmount: zero, FeeCurrency: "USD"}
+	}
+	ts := []*models.Trade{
+		trade(1, time.Date(2018, 1, 10, 0, 0, 0, 0, time.UTC), "BUY", 2, 400),
+		// sold at a loss of 200, half bought back within 30 days and held
+		trade(2, time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC), "SELL", 2, 200),
+		trade(3, time.Date(2018, 6, 15, 0, 0, 0, 0, time.UTC), "BUY", 1, 90),
+	}
+
+	f := &Form8949{Currency: "USD", Method: FIFO, Year: 2018}
+	if err := f.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if len(f.ShortTerm) != 1 {
+		t.Fatalf("Should have 1 short term row, not %v", len(f.ShortTerm))
+	}
+	row := f.ShortTerm[0]
+	if row.Code != "W" || !theSame(row.Adjustment, decimal.NewFromFloat(100)) || !theSame(row.Gain, decimal.NewFromFloat(-100)) {
+		t.Errorf("Half the loss should be adjusted. Got: %+v", row)
+	}
+
+	b, err := f.CSV()
+	if err != nil {
+		t.Fatalf("Should write the CSV. Got: %v", err)
+	}
+	if l := "2 AAA,01/10/2018,06/01/2018,200.00,400.00,W,100.00,-100.00\n"; !strings.Contains(string(b), l) {
+		t.Errorf("CSV should have the line %v. Got:\n%s", l, b)
+	}
+}
+
+func TestSchedule3(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	trade := func(date time.Time, action string, amount, base, fee float64) *models.Trade {
+		return &models.Trade{Date: date, Action: action, Amount: decimal.NewFromFloat(amount), Currency: "AAA", BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "CAD", FeeAmount: decimal.NewFromFloat(fee), FeeCurrency: "CAD"}
+	}
+	ts := []*models.Trade{
+		trade(time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC), "BUY", 2, 200, 0),
+		trade(time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC), "BUY", 2, 400, 0),
+		// ACB = 600 * 2/4 = 300, gain = 500 - 300 - 10 = 190
+		trade(time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), "SELL", 2, 500, 10),
+		// ACB = 300 * 1/2 = 150, loss = 60
+		trade(time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), "SELL", 1, 90, 0),
+	}
+
+	r := &Schedule3{Currency: "CAD"}
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if len(r.Rows) != 2 || r.Rows[0].YearAcquired != 2016 || !theSame(r.Rows[0].Expenses, decimal.NewFromFloat(10)) {
+		t.Fatalf("Should have 2 rows, acquired in 2016. Got: %+v", r.Rows)
+	}
+
+	exp := []*Schedule3Total{
+		{Year: 2017, Proceeds: decimal.NewFromFloat(500), ACB: decimal.NewFromFloat(300), Gain: decimal.NewFromFloat(190), Taxable: decimal.NewFromFloat(95)},
+		{Year: 2018, Proceeds: decimal.NewFromFloat(90), ACB: decimal.NewFromFloat(150), Gain: decimal.NewFromFloat(-60), Taxable: zero},
+	}
+	totals := r.Totals()
+	for i, e := range exp {
+		a := totals[i]
+		if a.Year != e.Year || !theSame(a.Proceeds, e.Proceeds) || !theSame(a.ACB, e.ACB) || !theSame(a.Gain, e.Gain) || !theSame(a.Taxable, e.Taxable) {
+			t.Errorf("Wrong totals. Got: %+v, want: %+v", a, e)
+		}
+	}
+
+	r.Year = 2017
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	b, err := r.CSV()
+	if err != nil {
+		t.Fatalf("Should write the CSV. Got: %v", err)
+	}
+	for _, l := range []string{
+		"2 AAA,2016,500.00,300.00,10.00,190.00,0.00",
+		"Taxable capital gain,,,,,95.00,",
+	} {
+		if !strings.Contains(string(b), l+"\n") {
+			t.Errorf("CSV should have the line %v. Got:\n%s", l, b)
+		}
+	}
+	if strings.Contains(string(b), "2018") {
+		t.Errorf("CSV should only have 2017. Got:\n%s", b)
+	}
+
+	// a year without sales still has its header and a zero total
+	r.Year = 2019
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if b, err = r.CSV(); err != nil {
+		t.Fatalf("Should write the CSV. Got: %v", err)
+	}
+	for _, l := range []string{
+		`"Schedule 3 2019, other properties, amounts in CAD"`,
+		"Description,Year of acquisition,Proceeds of disposition,Adjusted cost base,Outlays and expenses,Gain (or loss),Superficial loss denied",
+		"Total,,0.00,0.00,0.00,0.00,",
+		"Taxable capital gain,,,,,0.00,",
+	} {
+		if !strings.Contains(string(b), l+"\n") {
+			t.Errorf("CSV should have the line %v. Got:\n%s", l, b)
+		}
+	}
+}
+
+func TestParseAsOf(t *testing.T) {
+	now := time.Date(2019, 4, 10, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		asOf     string
+		from, to time.Time
+	}{
+		{"Today", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2019, 4, 10, 23, 59, 59, 999999999, time.UTC)},
+		{"2017", time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 12, 31, 23, 59, 59, 999999999, time.UTC)},
+		{"2018-06-30", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2018, 6, 30, 23, 59, 59, 999999999, time.UTC)},
+	}
+	for _, tt := range tests {
+		from, to, err := ParseAsOf(tt.asOf, now)
+		if err != nil || !from.Equal(tt.from) || !to.Equal(tt.to) {
+			t.Errorf("%v should be %v to %v. Got: %v to %v, %v", tt.asOf, tt.from, tt.to, from, to, err)
+		}
+	}
+	if _, _, err := ParseAsOf("EOY", now); err == nil {
+		t.Errorf("Should not read an invalid as of.")
+	}
+}
+
+func TestBuildHoldingsAsOf(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	trade := func(date time.Time, action string, amount, base float64) *models.Trade {
+		return &models.Trade{Date: date, Action: action, Amount: decimal.NewFromFloat(amount), Currency: "AAA", BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"}
+	}
+	ts := []*models.Trade{
+		trade(time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC), "BUY", 4, 400),
+		// gain = 300 - 100 = 200, bal = 3, cost = 300
+		trade(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC), "SELL", 1, 300),
+		// bal = 5, cost = 300 + 500 = 800
+		trade(time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), "BUY", 2, 500),
+		// gain = 400 - 160 = 240, bal = 4, cost = 640
+		trade(time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC), "SELL", 1, 400),
+	}
+
+	tests := []struct {
+		asOf                  string
+		amount, acb, realized float64
+	}{
+		{"2017", 3, 300, 200},
+		{"2018-03-01", 5, 800, 0},
+		{"2018", 4, 640, 240},
+	}
+	for _, tt := range tests {
+		from, to, _ := ParseAsOf(tt.asOf, time.Now())
+		r := &Holdings{Currency: "CAD", From: from, AsOf: to}
+		if err := r.Build(ts, c); err != nil {
+			t.Fatalf("Should build correctly. Got: %v", err)
+		}
+		if len(r.Items) != 1 || !theSame(r.Items[0].Amount, decimal.NewFromFloat(tt.amount)) || !theSame(r.Items[0].ACB, decimal.NewFromFloat(tt.acb)) {
+			t.Errorf("As of %v should hold %v at %v. Got: %+v", tt.asOf, tt.amount, tt.acb, r.Items[0])
+		}
+		if !theSame(r.Realized, decimal.NewFromFloat(tt.realized)) {
+			t.Errorf("As of %v should have realized %v. Got: %v", tt.asOf, tt.realized, r.Realized)
+		}
+	}
+}
+
+func TestBuildHoldingsValue(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	ts := []*models.Trade{
+		&models.Trade{Date: time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC), Action: "BUY", Amount: decimal.NewFromFloat(4), Currency: "AAA", BaseAmount: decimal.NewFromFloat(640), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+	}
+	_, end, _ := ParseAsOf("2018", time.Now())
+
+	// AAA is worth 300 CAD at the end of 2018
+	price := Converter{
+		Convert: func(amount decimal.Decimal, from, to string, on time.Time) decimal.Decimal {
+			if from == to {
+				return amount
+			}
+			if from != "AAA" || !on.Equal(end) {
+				return zero
+			}
+			return amount.Mul(decimal.NewFromFloat(300))
+		},
+	}
+	r := &Holdings{Currency: "CAD", AsOf: end}
+	if err := r.Build(ts, price); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	// value = 4 * 300 = 1200, gain = 1200 - 640 = 560, percent = 560 / 640
+	i := r.Items[0]
+	if !theSame(i.Value, decimal.NewFromFloat(1200)) || !theSame(i.Gain, decimal.NewFromFloat(560)) || !theSame(i.Percent, decimal.NewFromFloat(0.875)) {
+		t.Errorf("Should be worth 1200 with a gain of 560 (87.5%%). Got: %+v", i)
+	}
+
+	rr := Valuation(ts, "CAD", end)
+	if rr == nil || rr.Timestamp != end.Unix() || len(rr.Rates) != 1 || rr.Rates[0].Currency != "AAA" {
+		t.Errorf("Should request the AAA rate at %v. Got: %+v", end.Unix(), rr)
+	}
+	if rr := Valuation(ts, "CAD", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)); rr != nil {
+		t.Errorf("Should need no rates before the first trade. Got: %+v", rr)
+	}
+}
+
 func TestBuildACB(t *testing.T) {
-	time := time.Now()
-	r := &ACB{Currency: "CAD", AsOf: time}
-	c, d := r.Build()
-	if c != "CAD" {
-		t.Errorf("Build did not set currency. Got: %v, want: %v", c, "CAD")
+	r := &ACB{}
+	if err := r.Build(trades, c); err == nil {
+		t.Errorf("Should require currency set.")
+	}
+
+	zero := decimal.NewFromFloat(0)
+	trade := func(date time.Time, action string, amount, base, fee float64) *models.Trade {
+		return &models.Trade{Date: date, Action: action, Amount: decimal.NewFromFloat(amount), Currency: "AAA", BaseAmount: decimal.NewFromFloat(base), BaseCurrency: "CAD", FeeAmount: decimal.NewFromFloat(fee), FeeCurrency: "CAD"}
+	}
+	ts := []*models.Trade{
+		trade(time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC), "BUY", 4, 400, 0),
+		// ACB = 100, net income = 300 - 100 - 10 = 190
+		trade(time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC), "SELL", 1, 300, 10),
+		// ACB = 200, net income = 100 - 200 = -100
+		trade(time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC), "SELL", 2, 100, 0),
+		trade(time.Date(2018, 8, 1, 0, 0, 0, 0, time.UTC), "SELL", 1, 400, 0),
 	}
-	if d != time {
-		t.Errorf("Build did not set currency. Got: %v, want: %v", d, time)
+
+	from, to, _ := ParseAsOf("2017", time.Now())
+	r = &ACB{Currency: "CAD", From: from, AsOf: to}
+	if err := r.Build(ts, c); err != nil {
+		t.Fatalf("Should build correctly. Got: %v", err)
+	}
+	if len(r.Items) != 2 {
+		t.Fatalf("Should have the 2 sales of 2017, not %v.", len(r.Items))
+	}
+
+	exp := &ACBItem{Asset: "AAA", Acquired: 2016, Amount: decimal.NewFromFloat(1), Proceeds: decimal.NewFromFloat(300), ACB: decimal.NewFromFloat(100), Expenses: decimal.NewFromFloat(10), Superficial: zero, NetIncome: decimal.NewFromFloat(190), Gain: decimal.NewFromFloat(1.9)}
+	i := r.Items[0]
+	if i.Asset != exp.Asset || i.Acquired != exp.Acquired || !theSame(i.Amount, exp.Amount) || !theSame(i.Proceeds, exp.Proceeds) ||
+		!theSame(i.ACB, exp.ACB) || !theSame(i.Expenses, exp.Expenses) || !theSame(i.NetIncome, exp.NetIncome) || !theSame(i.Gain, exp.Gain) {
+		t.Errorf("Wrong item. Got: %+v, want: %+v", i, exp)
+	}
+	if !theSame(r.Total, decimal.NewFromFloat(90)) {
+		t.Errorf("Total should be 90. Got: %v", r.Total)
 	}
 }
 
@@ -161,3 +806,40 @@ func theSame(x, y decimal.Decimal) bool {
 	th := decimal.NewFromFloat(0.000001)
 	return x.Sub(y).Abs().LessThan(th)
 }
+
+func TestObserved(t *testing.T) {
+	zero := decimal.NewFromFloat(0)
+	day := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	ts := []*models.Trade{
+		// 1 BTC at 12000 CAD an hour before
+		&models.Trade{Date: day.Add(-time.Hour), Action: "BUY", Amount: decimal.NewFromFloat(0.5), Currency: "BTC", BaseAmount: decimal.NewFromFloat(6000), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+		// 1 BTC at 12500 CAD half an hour after, the closest
+		&models.Trade{Date: day.Add(30 * time.Minute), Action: "SELL", Amount: decimal.NewFromFloat(0.2), Currency: "BTC", BaseAmount: decimal.NewFromFloat(2500), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+		// 1 CAD for 0.0025 ETH, quoted the other way
+		&models.Trade{Date: day, Action: "BUY", Amount: decimal.NewFromFloat(5), Currency: "CAD", BaseAmount: decimal.NewFromFloat(0.0125), BaseCurrency: "ETH", FeeAmount: zero, FeeCurrency: "ETH"},
+		// income isn't a price
+		&models.Trade{Date: day, Action: "STAKING", Amount: decimal.NewFromFloat(1), Currency: "XTZ", BaseAmount: decimal.NewFromFloat(3), BaseCurrency: "CAD", FeeAmount: zero, FeeCurrency: "CAD"},
+	}
+	o := Observed(ts, 2*time.Hour, c)
+
+	tcs := []struct {
+		amount float64
+		from   string
+		on     time.Time
+		value  float64
+	}{
+		{2, "BTC", day, 25000},
+		{2, "BTC", day.Add(-2 * time.Hour), 24000},
+		// outside the window, the converter doubles it
+		{2, "BTC", day.Add(3 * time.Hour), 4},
+		{1, "ETH", day, 400},
+		// the converter, not the income
+		{1, "XTZ", day, 2},
+	}
+	for _, tc := range tcs {
+		v := o.Convert(decimal.NewFromFloat(tc.amount), tc.from, "CAD", tc.on)
+		if !theSame(v, decimal.NewFromFloat(tc.value)) {
+			t.Errorf("%v %v on %v should be worth %v. Got: %v", tc.amount, tc.from, tc.on, tc.value, v)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package reports
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// InclusionRate is the part of a capital gain that is taxable in Canada
+var InclusionRate = decimal.NewFromFloat(0.5)
+
+// Schedule3 lists the sales as the "other properties" section of
+// CRA Schedule 3 does, at average cost with superficial losses denied
+type Schedule3 struct {
+	Currency string
+	Year     int // every year when 0
+	Rows     []*Schedule3Row
+}
+
+// Schedule3Row is a sale of an asset
+type Schedule3Row struct {
+	Sold         time.Time
+	Description  string
+	YearAcquired int
+	Proceeds     decimal.Decimal
+	ACB          decimal.Decimal
+	Expenses     decimal.Decimal // outlays and expenses
+	Gain         decimal.Decimal // or loss, without the denied part
+	Superficial  decimal.Decimal // loss denied
+}
+
+// Schedule3Total of the sales of a tax year
+type Schedule3Total struct {
+	Year     int
+	Proceeds decimal.Decimal
+	ACB      decimal.Decimal
+	Expenses decimal.Decimal
+	Gain     decimal.Decimal
+	Taxable  decimal.Decimal // none for a net loss, it's carried over
+}
+
+// Build the report
+func (r *Schedule3) Build(ts []*models.Trade, c Converter) error {
+	if r.Currency == "" {
+		return errors.New("Invalid currency")
+	}
+
+	trades, err := expandAgainstBase(ts, r.Currency, c)
+	if err != nil {
+		return err
+	}
+	_, _, ds, err := tally(trades, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	r.Rows = nil
+	for _, d := range ds {
+		if r.Year != 0 && d.Date.Year() != r.Year {
+			continue
+		}
+		r.Rows = append(r.Rows, &Schedule3Row{
+			Sold:         d.Date,
+			Description:  d.Amount.String() + " " + d.Asset,
+			YearAcquired: d.Acquired.Year(),
+			Proceeds:     d.Proceeds,
+			ACB:          d.ACB,
+			Expenses:     d.Expenses,
+			Gain:         d.Gain,
+			Superficial:  d.Superficial,
+		})
+	}
+	return nil
+}
+
+// Totals of the rows by the year sold, a tax year without sales totals zero
+func (r *Schedule3) Totals() []*Schedule3Total {
+	years := make(map[int]*Schedule3Total)
+	if r.Year != 0 {
+		years[r.Year] = &Schedule3Total{Year: r.Year}
+	}
+	for _, row := range r.Rows {
+		t := years[row.Sold.Year()]
+		if t == nil {
+			t = &Schedule3Total{Year: row.Sold.Year()}
+			years[t.Year] = t
+		}
+		t.Proceeds = t.Proceeds.Add(row.Proceeds)
+		t.ACB = t.ACB.Add(row.ACB)
+		t.Expenses = t.Expenses.Add(row.Expenses)
+		t.Gain = t.Gain.Add(row.Gain)
+	}
+
+	var ts []*Schedule3Total
+	for _, t := range years {
+		if t.Gain.IsPositive() {
+			t.Taxable = t.Gain.Mul(InclusionRate)
+		}
+		ts = append(ts, t)
+	}
+	sort.Slice(ts, func(i, j int) bool {
+		return ts[i].Year < ts[j].Year
+	})
+	return ts
+}
+
+// CSV writes the rows of each year with their totals
+func (r *Schedule3) CSV() ([]byte, error) {
+	header := []string{"Description", "Year of acquisition", "Proceeds of disposition", "Adjusted cost base", "Outlays and expenses", "Gain (or loss)", "Superficial loss denied"}
+	records := [][]string{}
+
+	for _, t := range r.Totals() {
+		y := strconv.Itoa(t.Year)
+		records = append(records, []string{"Schedule 3 " + y + ", other properties, amounts in " + r.Currency}, header)
+		for _, row := range r.Rows {
+			if row.Sold.Year() != t.Year {
+				continue
+			}
+			records = append(records, []string{
+				row.Description,
+				strconv.Itoa(row.YearAcquired),
+				row.Proceeds.StringFixed(2),
+				row.ACB.StringFixed(2),
+				row.Expenses.StringFixed(2),
+				row.Gain.StringFixed(2),
+				row.Superficial.StringFixed(2),
+			})
+		}
+		records = append(records,
+			[]string{"Total", "", t.Proceeds.StringFixed(2), t.ACB.StringFixed(2), t.Expenses.StringFixed(2), t.Gain.StringFixed(2), ""},
+			[]string{"Taxable capital gain", "", "", "", "", t.Taxable.StringFixed(2), ""},
+			[]string{},
+		)
+	}
+
+	b := &bytes.Buffer{}
+	w := csv.NewWriter(b)
+	w.WriteAll(records)
+
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
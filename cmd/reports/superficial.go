@@ -0,0 +1,55 @@
+package reports
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// a loss is superficial when the asset is bought back within this long
+// before or after the sale, and still held at the end of it
+const superficialPeriod = 30 * 24 * time.Hour
+
+// DeniedLoss returns the part of the loss on sale s that is superficial.
+// Units bought in the 30 days before or after the sale and still held
+// 30 days after it deny the loss in proportion to the units sold.
+// Actions of ts are compared in any case.
+func DeniedLoss(ts []*models.Trade, s *models.Trade, loss decimal.Decimal) decimal.Decimal {
+	zero := decimal.NewFromFloat(0)
+	if !loss.IsPositive() || !s.Amount.IsPositive() {
+		return zero
+	}
+
+	start, end := s.Date.Add(-superficialPeriod), s.Date.Add(superficialPeriod)
+	var bought, held decimal.Decimal
+	for _, t := range ts {
+		if t == s || t.Currency != s.Currency || t.Date.After(end) {
+			continue
+		}
+		switch strings.ToUpper(t.Action) {
+		case "BUY":
+			held = held.Add(t.Amount)
+			if !t.Date.Before(start) {
+				bought = bought.Add(t.Amount)
+			}
+		case "SELL", "FEE":
+			held = held.Sub(t.Amount)
+		}
+	}
+	held = held.Sub(s.Amount)
+
+	// units substituted for those sold
+	n := s.Amount
+	if bought.LessThan(n) {
+		n = bought
+	}
+	if held.LessThan(n) {
+		n = held
+	}
+	if !n.IsPositive() {
+		return zero
+	}
+	return loss.Mul(n).Div(s.Amount)
+}
@@ -0,0 +1,108 @@
+// Package transfers pairs withdrawals from one account with the deposits
+// they became in another, so moving an asset doesn't look like a sale.
+package transfers
+
+import (
+	"sort"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+// a deposit is expected within this window around its withdrawal, a little
+// before is allowed since exchanges don't all stamp the same moment
+const (
+	before = time.Hour
+	after  = 72 * time.Hour
+)
+
+// maxFee is the largest part of a withdrawal the network fee can be
+var maxFee = decimal.NewFromFloat(0.05)
+
+// Match pairs SEND trades with RECEIVE trades of the same asset held in
+// another account. Trades of the existing transfers are left out.
+func Match(ts []*models.Trade, existing []*models.Transfer) (trs []*models.Transfer) {
+	used := make(map[uint]bool)
+	for _, tr := range existing {
+		used[tr.WithdrawalID] = true
+		used[tr.DepositID] = true
+	}
+
+	var sends, receives []*models.Trade
+	for _, t := range ts {
+		if used[t.ID] {
+			continue
+		}
+		switch t.Action {
+		case "SEND":
+			sends = append(sends, t)
+		case "RECEIVE":
+			receives = append(receives, t)
+		}
+	}
+	sort.SliceStable(sends, func(i, j int) bool {
+		return sends[i].Date.Before(sends[j].Date)
+	})
+
+	for _, s := range sends {
+		out := outflow(s)
+
+		var best *models.Trade
+		var bestFee decimal.Decimal
+		for _, r := range receives {
+			if used[r.ID] || r.Currency != s.Currency || r.AccountID == s.AccountID {
+				continue
+			}
+			if r.Date.Before(s.Date.Add(-before)) || r.Date.After(s.Date.Add(after)) {
+				continue
+			}
+
+			fee := out.Sub(r.Amount)
+			if fee.IsNegative() || fee.GreaterThan(out.Mul(maxFee)) {
+				continue
+			}
+
+			// closest amount, then closest in time
+			if best == nil || fee.LessThan(bestFee) ||
+				(fee.Equal(bestFee) && gap(s, r) < gap(s, best)) {
+				best, bestFee = r, fee
+			}
+		}
+		if best == nil {
+			continue
+		}
+
+		used[best.ID] = true
+		trs = append(trs, &models.Transfer{
+			Date:          s.Date,
+			Asset:         s.Currency,
+			Amount:        best.Amount,
+			FromAccountID: s.AccountID,
+			ToAccountID:   best.AccountID,
+			FeeAmount:     bestFee,
+			WithdrawalID:  s.ID,
+			DepositID:     best.ID,
+			UserID:        s.UserID,
+		})
+	}
+	return
+}
+
+// outflow is what left the account on a withdrawal, with the fee when
+// it was charged in the asset sent
+func outflow(s *models.Trade) decimal.Decimal {
+	if s.FeeCurrency == s.Currency {
+		return s.Amount.Add(s.FeeAmount)
+	}
+	return s.Amount
+}
+
+// gap between a withdrawal and a deposit
+func gap(s, r *models.Trade) time.Duration {
+	d := r.Date.Sub(s.Date)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,54 @@
+package transfers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mathieugilbert/cryptotax/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestMatch(t *testing.T) {
+	d := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	zero := decimal.NewFromFloat(0)
+
+	ts := []*models.Trade{
+		// fee charged in the asset sent
+		{ID: 1, AccountID: 1, Date: d, Action: "SEND", Amount: decimal.NewFromFloat(1), Currency: "ETH", FeeAmount: decimal.NewFromFloat(0.005), FeeCurrency: "ETH"},
+		{ID: 2, AccountID: 2, Date: d.Add(20 * time.Minute), Action: "RECEIVE", Amount: decimal.NewFromFloat(1), Currency: "ETH", FeeAmount: zero, FeeCurrency: "ETH"},
+		// fee taken from the amount
+		{ID: 3, AccountID: 2, Date: d.Add(24 * time.Hour), Action: "SEND", Amount: decimal.NewFromFloat(0.5), Currency: "BTC", FeeAmount: decimal.NewFromFloat(10), FeeCurrency: "CAD"},
+		{ID: 4, AccountID: 1, Date: d.Add(25 * time.Hour), Action: "RECEIVE", Amount: decimal.NewFromFloat(0.4995), Currency: "BTC", FeeAmount: zero, FeeCurrency: "BTC"},
+		// too late, and too much missing
+		{ID: 5, AccountID: 1, Date: d, Action: "SEND", Amount: decimal.NewFromFloat(2), Currency: "LTC", FeeAmount: zero, FeeCurrency: "LTC"},
+		{ID: 6, AccountID: 2, Date: d.AddDate(0, 0, 5), Action: "RECEIVE", Amount: decimal.NewFromFloat(2), Currency: "LTC", FeeAmount: zero, FeeCurrency: "LTC"},
+		{ID: 7, AccountID: 2, Date: d, Action: "RECEIVE", Amount: decimal.NewFromFloat(1), Currency: "LTC", FeeAmount: zero, FeeCurrency: "LTC"},
+		// same account
+		{ID: 8, AccountID: 1, Date: d, Action: "RECEIVE", Amount: decimal.NewFromFloat(2), Currency: "LTC", FeeAmount: zero, FeeCurrency: "LTC"},
+	}
+	trs := Match(ts, nil)
+	if len(trs) != 2 {
+		t.Fatalf("Should match 2 transfers, not %v", len(trs))
+	}
+
+	x := trs[0]
+	if x.WithdrawalID != 1 || x.DepositID != 2 || x.FromAccountID != 1 || x.ToAccountID != 2 {
+		t.Errorf("Wrong transfer: %+v", x)
+	}
+	if !x.FeeAmount.Equal(decimal.NewFromFloat(0.005)) {
+		t.Errorf("Wrong network fee. Got: %v, want: %v", x.FeeAmount, 0.005)
+	}
+
+	x = trs[1]
+	if x.WithdrawalID != 3 || x.DepositID != 4 || !x.Amount.Equal(decimal.NewFromFloat(0.4995)) {
+		t.Errorf("Wrong transfer: %+v", x)
+	}
+	if !x.FeeAmount.Equal(decimal.NewFromFloat(0.0005)) {
+		t.Errorf("Wrong network fee. Got: %v, want: %v", x.FeeAmount, 0.0005)
+	}
+
+	// matched trades aren't matched again
+	if trs = Match(ts, trs); len(trs) != 0 {
+		t.Errorf("Should not match again. Got: %v", len(trs))
+	}
+}
@@ -2,13 +2,16 @@ package main
 
 import (
 	"encoding/csv"
+	"flag"
 	"log"
 	"net/http"
+	"path/filepath"
 	"runtime"
 
 	_ "github.com/jinzhu/gorm/dialects/postgres" // db driver
 	"github.com/julienschmidt/httprouter"
 	"github.com/mathieugilbert/cryptotax/cmd/config"
+	"github.com/mathieugilbert/cryptotax/cmd/exchange"
 	"github.com/mathieugilbert/cryptotax/cmd/parsers"
 	"github.com/mathieugilbert/cryptotax/database"
 	"github.com/mathieugilbert/cryptotax/models"
@@ -21,7 +24,7 @@ type Env struct {
 
 // Parser is an interface for exchange-specific parsing logic
 type Parser interface {
-	Parse(*csv.Reader) ([]parsers.Trade, error)
+	Parse(*csv.Reader) (*parsers.Result, error)
 }
 
 // Presenter defines template data
@@ -56,6 +59,8 @@ var (
 	SupportedExchanges = []string{
 		"Coinbase",
 		"Kucoin",
+		"Binance",
+		"Kraken",
 		"Cryptotax",
 	}
 	// TemplateFiles is a list of common template files needed for rendering
@@ -77,7 +82,11 @@ func init() {
 }
 
 // main: connect to database, set up router handlers and start web server.
+// With -prices, loads the price file into the database instead.
 func main() {
+	prices := flag.String("prices", "", "price CSV file to load: date,from,to,rate")
+	flag.Parse()
+
 	// initialize database
 	db, err := initDB()
 	if err != nil {
@@ -86,6 +95,18 @@ func main() {
 	// run the latest migrations
 	database.Migrate(Config.DBString())
 
+	// keep the rates fetched
+	exchange.Store = db
+
+	if *prices != "" {
+		n, err := exchange.LoadPrices(*prices, filepath.Base(*prices))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Loaded %v prices.", n)
+		return
+	}
+
 	// wrap DB
 	env := &Env{db}
 
@@ -106,14 +127,27 @@ func main() {
 	router.POST("/upload", env.wrapHandler(env.loggedInOnly(env.postUploadAsync)))
 	router.DELETE("/file", env.wrapHandler(env.loggedInOnly(env.deleteFileAsync)))
 	router.GET("/filetrades", env.wrapHandler(env.loggedInOnly(env.getFileTradesAsync)))
+	router.POST("/profile", env.wrapHandler(env.loggedInOnly(env.postProfileAsync)))
+	router.DELETE("/profile", env.wrapHandler(env.loggedInOnly(env.deleteProfileAsync)))
+	router.POST("/file/account", env.wrapHandler(env.loggedInOnly(env.postFileAccountAsync)))
+	router.POST("/account", env.wrapHandler(env.loggedInOnly(env.postAccountAsync)))
+	router.DELETE("/account", env.wrapHandler(env.loggedInOnly(env.deleteAccountAsync)))
 
 	router.GET("/trades", env.wrapHandler(env.loggedInOnly(env.getTrades)))
 	router.POST("/trade", env.wrapHandler(env.loggedInOnly(env.postTradeAsync)))
 	router.DELETE("/trade", env.wrapHandler(env.loggedInOnly(env.deleteTradeAsync)))
 
+	router.GET("/transfers", env.wrapHandler(env.loggedInOnly(env.getTransfers)))
+	router.POST("/transfer", env.wrapHandler(env.loggedInOnly(env.postTransferAsync)))
+	router.POST("/transfers/match", env.wrapHandler(env.loggedInOnly(env.postMatchTransfersAsync)))
+	router.DELETE("/transfer", env.wrapHandler(env.loggedInOnly(env.deleteTransferAsync)))
+
 	router.GET("/reports", env.wrapHandler(env.loggedInOnly(env.getReports)))
-	router.GET("/rateRequest", env.wrapHandler(env.loggedInOnly(env.getRateRequestAsync)))
 	router.POST("/report", env.wrapHandler(env.loggedInOnly(env.postReportAsync)))
+	router.POST("/report/8949", env.wrapHandler(env.loggedInOnly(env.postForm8949)))
+	router.POST("/report/schedule3", env.wrapHandler(env.loggedInOnly(env.postSchedule3)))
+	router.POST("/lot", env.wrapHandler(env.loggedInOnly(env.postLotSelectionAsync)))
+	router.DELETE("/lot", env.wrapHandler(env.loggedInOnly(env.deleteLotSelectionAsync)))
 
 	// serve static files
 	router.ServeFiles("/web/js/*filepath", http.Dir("web/js"))
@@ -133,6 +167,25 @@ func loadConfig() {
 		log.Fatal(err)
 	}
 	Config = c
+
+	ps, err := exchange.NewProviders(c.Rates.Providers, c.Rates.CSVFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	exchange.Providers = ps
+	exchange.Fallback = c.Rates.Fallback
+
+	if _, err := c.ObservedWindow(); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(c.Rates.BankOfCanada) > 0 {
+		boc, err := exchange.NewBankOfCanada(c.Rates.BankOfCanada...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exchange.Fiat = boc
+	}
 }
 
 // initDB initializes the DB connection and returns a new instance
@@ -175,6 +228,6 @@ func (env *Env) downloadTrades(w http.ResponseWriter, r *http.Request, _ httprou
 }
 */
 
-func parse(p Parser, r *csv.Reader) ([]parsers.Trade, error) {
+func parse(p Parser, r *csv.Reader) (*parsers.Result, error) {
 	return p.Parse(r)
 }
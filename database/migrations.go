@@ -1,7 +1,10 @@
 package database
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -597,6 +600,285 @@ func start(db *gorm.DB) error {
 				return nil
 			},
 		},
+		// add profiles table, for mapping the columns of custom files
+		{
+			ID: "20261018083512",
+			Migrate: func(tx *gorm.DB) error {
+				type Profile struct {
+					ID                 uint      `gorm:"primary_key"`
+					CreatedAt          time.Time `gorm:"not null"`
+					Name               string    `gorm:"not null"`
+					DateColumn         string    `gorm:"not null"`
+					DateLayout         string    ``
+					ActionColumn       string    `gorm:"not null"`
+					BuyWords           string    `gorm:"not null"`
+					SellWords          string    `gorm:"not null"`
+					AmountColumn       string    `gorm:"not null"`
+					CurrencyColumn     string    `gorm:"not null"`
+					BaseAmountColumn   string    `gorm:"not null"`
+					BaseCurrencyColumn string    `gorm:"not null"`
+					FeeAmountColumn    string    ``
+					FeeCurrencyColumn  string    ``
+					UserID             uint      `gorm:"not null"`
+				}
+				if err := tx.CreateTable(&Profile{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Profile{}).AddForeignKey("user_id", "users(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("profiles").Error
+			},
+		},
+		// add fingerprint to trades, to find the same trade in overlapping files
+		{
+			ID: "20261018101544",
+			Migrate: func(tx *gorm.DB) error {
+				type Trade struct {
+					Fingerprint string ``
+				}
+				if err := tx.AutoMigrate(&Trade{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Trade{}).AddIndex("idx_trade_user_id_fingerprint", "user_id", "fingerprint").Error; err != nil {
+					return err
+				}
+
+				// fingerprint the trades of existing files, hashed as they
+				// were at this migration
+				rows, err := tx.Raw("SELECT t.id, t.date, t.action, t.amount, t.currency, t.base_amount, t.base_currency, f.source FROM trades t JOIN files f ON f.id = t.file_id").Rows()
+				if err != nil {
+					return err
+				}
+				fps := make(map[uint]string)
+				for rows.Next() {
+					var id uint
+					var date time.Time
+					var action, currency, baseCurrency, source string
+					var amount, baseAmount decimal.Decimal
+					if err := rows.Scan(&id, &date, &action, &amount, &currency, &baseAmount, &baseCurrency, &source); err != nil {
+						rows.Close()
+						return err
+					}
+					v := strings.Join([]string{
+						strings.ToUpper(source),
+						date.UTC().Format(time.RFC3339),
+						action,
+						amount.String(),
+						currency,
+						baseAmount.String(),
+						baseCurrency,
+					}, "|")
+					h := sha1.Sum([]byte(v))
+					fps[id] = hex.EncodeToString(h[:])
+				}
+				rows.Close()
+
+				for id, fp := range fps {
+					if err := tx.Exec("UPDATE trades SET fingerprint = ? WHERE id = ?", fp, id).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				type Trade struct{}
+				if err := tx.Model(&Trade{}).RemoveIndex("idx_trade_user_id_fingerprint").Error; err != nil {
+					return err
+				}
+				return tx.Model(&Trade{}).DropColumn("fingerprint").Error
+			},
+		},
+		// add transfers table, for moving assets between own accounts
+		{
+			ID: "20261018112406",
+			Migrate: func(tx *gorm.DB) error {
+				type Transfer struct {
+					ID           uint            `gorm:"primary_key"`
+					CreatedAt    time.Time       `gorm:"not null"`
+					Date         time.Time       `gorm:"not null"`
+					Asset        string          `gorm:"not null"`
+					Amount       decimal.Decimal `gorm:"type:decimal;not null"`
+					FromAccount  string          `gorm:"not null"`
+					ToAccount    string          `gorm:"not null"`
+					FeeAmount    decimal.Decimal `gorm:"type:decimal;not null"`
+					TxHash       string          ``
+					WithdrawalID *uint           ``
+					DepositID    *uint           ``
+					UserID       uint            `gorm:"not null"`
+				}
+				if err := tx.CreateTable(&Transfer{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Transfer{}).AddForeignKey("user_id", "users(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				// a deleted file takes its matched transfers with it
+				if err := tx.Model(&Transfer{}).AddForeignKey("withdrawal_id", "trades(id)", "CASCADE", "RESTRICT").Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Transfer{}).AddForeignKey("deposit_id", "trades(id)", "CASCADE", "RESTRICT").Error; err != nil {
+					return err
+				}
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("transfers").Error
+			},
+		},
+		// add accounts table, files and trades are held in one
+		// file sources and transfer names become accounts
+		{
+			ID: "20261018121930",
+			Migrate: func(tx *gorm.DB) error {
+				type Account struct {
+					ID        uint      `gorm:"primary_key"`
+					CreatedAt time.Time `gorm:"not null"`
+					Name      string    `gorm:"not null"`
+					Kind      string    `gorm:"not null"`
+					UserID    uint      `gorm:"not null"`
+				}
+				if err := tx.CreateTable(&Account{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Account{}).AddForeignKey("user_id", "users(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Account{}).AddUniqueIndex("idx_account_user_id_name", "user_id", "name").Error; err != nil {
+					return err
+				}
+
+				type File struct {
+					AccountID *uint ``
+				}
+				type Trade struct {
+					AccountID *uint ``
+				}
+				type Transfer struct {
+					FromAccountID *uint ``
+					ToAccountID   *uint ``
+				}
+				if err := tx.AutoMigrate(&File{}, &Trade{}, &Transfer{}).Error; err != nil {
+					return err
+				}
+
+				// one account per file source, one for manual trades
+				steps := []string{
+					"INSERT INTO accounts (created_at, name, kind, user_id) SELECT DISTINCT now(), source, 'exchange', user_id FROM files",
+					"INSERT INTO accounts (created_at, name, kind, user_id) SELECT DISTINCT now(), 'Other', 'wallet', user_id FROM trades WHERE file_id IS NULL ON CONFLICT DO NOTHING",
+					"INSERT INTO accounts (created_at, name, kind, user_id) SELECT DISTINCT now(), from_account, 'wallet', user_id FROM transfers ON CONFLICT DO NOTHING",
+					"INSERT INTO accounts (created_at, name, kind, user_id) SELECT DISTINCT now(), to_account, 'wallet', user_id FROM transfers ON CONFLICT DO NOTHING",
+					"UPDATE files f SET account_id = a.id FROM accounts a WHERE a.user_id = f.user_id AND a.name = f.source",
+					"UPDATE trades t SET account_id = f.account_id FROM files f WHERE f.id = t.file_id",
+					"UPDATE trades t SET account_id = a.id FROM accounts a WHERE t.file_id IS NULL AND a.user_id = t.user_id AND a.name = 'Other'",
+					"UPDATE transfers t SET from_account_id = a.id FROM accounts a WHERE a.user_id = t.user_id AND a.name = t.from_account",
+					"UPDATE transfers t SET to_account_id = a.id FROM accounts a WHERE a.user_id = t.user_id AND a.name = t.to_account",
+					"ALTER TABLE files ALTER COLUMN account_id SET NOT NULL",
+					"ALTER TABLE trades ALTER COLUMN account_id SET NOT NULL",
+					"ALTER TABLE transfers ALTER COLUMN from_account_id SET NOT NULL",
+					"ALTER TABLE transfers ALTER COLUMN to_account_id SET NOT NULL",
+				}
+				for _, q := range steps {
+					if err := tx.Exec(q).Error; err != nil {
+						return err
+					}
+				}
+
+				if err := tx.Model(&File{}).AddForeignKey("account_id", "accounts(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Trade{}).AddForeignKey("account_id", "accounts(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Transfer{}).AddForeignKey("from_account_id", "accounts(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Transfer{}).AddForeignKey("to_account_id", "accounts(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&Transfer{}).DropColumn("from_account").Error; err != nil {
+					return err
+				}
+				return tx.Model(&Transfer{}).DropColumn("to_account").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				type Transfer struct {
+					FromAccount string ``
+					ToAccount   string ``
+				}
+				if err := tx.AutoMigrate(&Transfer{}).Error; err != nil {
+					return err
+				}
+				steps := []string{
+					"UPDATE transfers t SET from_account = a.name FROM accounts a WHERE a.id = t.from_account_id",
+					"UPDATE transfers t SET to_account = a.name FROM accounts a WHERE a.id = t.to_account_id",
+					"ALTER TABLE transfers DROP COLUMN from_account_id",
+					"ALTER TABLE transfers DROP COLUMN to_account_id",
+					"ALTER TABLE trades DROP COLUMN account_id",
+					"ALTER TABLE files DROP COLUMN account_id",
+				}
+				for _, q := range steps {
+					if err := tx.Exec(q).Error; err != nil {
+						return err
+					}
+				}
+				return tx.DropTable("accounts").Error
+			},
+		},
+		// add lot_selections table, for specific identification of the lots sold
+		{
+			ID: "20261018140257",
+			Migrate: func(tx *gorm.DB) error {
+				type LotSelection struct {
+					ID        uint            `gorm:"primary_key"`
+					CreatedAt time.Time       `gorm:"not null"`
+					SaleID    uint            `gorm:"not null"`
+					LotID     uint            `gorm:"not null"`
+					Asset     string          `gorm:"not null"`
+					Amount    decimal.Decimal `gorm:"type:decimal;not null"`
+					UserID    uint            `gorm:"not null"`
+				}
+				if err := tx.CreateTable(&LotSelection{}).Error; err != nil {
+					return err
+				}
+				if err := tx.Model(&LotSelection{}).AddForeignKey("user_id", "users(id)", "RESTRICT", "RESTRICT").Error; err != nil {
+					return err
+				}
+				// deleting either trade drops the selection
+				if err := tx.Model(&LotSelection{}).AddForeignKey("sale_id", "trades(id)", "CASCADE", "RESTRICT").Error; err != nil {
+					return err
+				}
+				return tx.Model(&LotSelection{}).AddForeignKey("lot_id", "trades(id)", "CASCADE", "RESTRICT").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("lot_selections").Error
+			},
+		},
+		// add prices table, to keep the historical rates fetched
+		{
+			ID: "20261018151204",
+			Migrate: func(tx *gorm.DB) error {
+				type Price struct {
+					ID        uint            `gorm:"primary_key"`
+					From      string          `gorm:"not null"`
+					To        string          `gorm:"not null"`
+					Date      time.Time       `gorm:"not null"`
+					Rate      decimal.Decimal `gorm:"type:decimal;not null"`
+					Source    string          `gorm:"not null"`
+					FetchedAt time.Time       `gorm:"not null"`
+				}
+				if err := tx.CreateTable(&Price{}).Error; err != nil {
+					return err
+				}
+				return tx.Model(&Price{}).AddUniqueIndex("idx_prices_from_to_date", "from", "to", "date").Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("prices").Error
+			},
+		},
 	})
 
 	return m.Migrate()
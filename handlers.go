@@ -21,6 +21,7 @@ import (
 	"github.com/lib/pq"
 	"github.com/mathieugilbert/cryptotax/cmd/parsers"
 	"github.com/mathieugilbert/cryptotax/cmd/reports"
+	"github.com/mathieugilbert/cryptotax/cmd/transfers"
 	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
 )
@@ -248,14 +249,32 @@ func (env *Env) getFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ps, err := env.db.GetProfiles(s.UserID)
+	if err != nil {
+		log.Printf("Error getting user profiles: %v\n", err)
+		http.Error(w, "Error retrieving profiles", http.StatusInternalServerError)
+		return
+	}
+
+	as, err := env.db.GetAccounts(s.UserID)
+	if err != nil {
+		log.Printf("Error getting user accounts: %v\n", err)
+		http.Error(w, "Error retrieving accounts", http.StatusInternalServerError)
+		return
+	}
+
 	pr := &Presenter{
 		LoggedIn:  true,
 		CSRFToken: s.CSRFToken,
 		Data: struct {
 			Exchanges []string
+			Profiles  []*models.Profile
+			Accounts  []*models.Account
 			Files     []*models.File
 		}{
 			Exchanges: SupportedExchanges,
+			Profiles:  ps,
+			Accounts:  as,
 			Files:     fs,
 		},
 	}
@@ -272,8 +291,13 @@ func (env *Env) postUploadAsync(w http.ResponseWriter, r *http.Request) {
 	type Data struct {
 		FileBytes string
 		Exchange  string
+		ProfileID uint
+		// account holding the file, one named after the exchange if not set
+		AccountID uint
 		FileName  string
-		CSRFToken string
+		// what to do with trades already imported: skip, merge or keep
+		Duplicates string
+		CSRFToken  string
 	}
 	// read request body
 	body, err := ioutil.ReadAll(r.Body)
@@ -297,6 +321,13 @@ func (env *Env) postUploadAsync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	switch data.Duplicates {
+	case "", "skip", "merge", "keep":
+	default:
+		http.Error(w, "Invalid duplicates choice", http.StatusBadRequest)
+		return
+	}
+
 	// base64 decode FileBytes
 	b, err := base64.StdEncoding.DecodeString(data.FileBytes)
 	if err != nil {
@@ -320,41 +351,154 @@ func (env *Env) postUploadAsync(w http.ResponseWriter, r *http.Request) {
 
 	// struct for response data
 	type Response struct {
-		FileID   uint   `json:"fileId"`
-		Name     string `json:"name"`
-		Date     string `json:"date"`
-		Exchange string `json:"exchange"`
-		Message  string `json:"message"`
-		Success  bool   `json:"success"`
+		FileID     uint                 `json:"fileId"`
+		AccountID  uint                 `json:"accountId"`
+		Name       string               `json:"name"`
+		Date       string               `json:"date"`
+		Exchange   string               `json:"exchange"`
+		Candidates []*parsers.Candidate `json:"candidates"`
+		Skipped    []*parsers.RowNote   `json:"skipped"`
+		Failed     []*parsers.RowError  `json:"failed"`
+		Duplicates []*models.Trade      `json:"duplicates"`
+		Message    string               `json:"message"`
+		Success    bool                 `json:"success"`
 	}
 	resp := &Response{
 		Name:    fileName,
 		Success: true,
 	}
 
+	// parse with the user's mapping profile when one is chosen
+	var p interface{}
+	if data.ProfileID > 0 {
+		s, _ := env.session(r)
+		pf, err := env.db.GetProfile(data.ProfileID, s.UserID)
+		if err != nil {
+			http.Error(w, "Invalid profile", http.StatusBadRequest)
+			return
+		}
+		p = &parsers.Mapped{Profile: pf}
+		data.Exchange = pf.Name
+	} else if data.Exchange == "" || data.Exchange == "Auto" {
+		// detect the exchange from the file header when not chosen
+		e, cs := parsers.Detect(csv.NewReader(bytes.NewReader(ba)), SupportedExchanges)
+		resp.Candidates = cs
+		data.Exchange = e
+
+		if e == "" {
+			resp.Success = false
+			if len(cs) == 0 {
+				resp.Message = "Unrecognized file format."
+			} else {
+				var names []string
+				for _, c := range cs {
+					names = append(names, c.Exchange)
+				}
+				resp.Message = fmt.Sprintf("Please select the exchange, could be: %v.", strings.Join(names, ", "))
+			}
+		}
+	}
+
+	if resp.Success && p == nil {
+		if p, err = parsers.NewParser(data.Exchange); err != nil {
+			resp.Success = false
+			resp.Message = fmt.Sprintf("File does not match %v format.", data.Exchange)
+		}
+	}
+
+	// Kraken trades take their fees from the ledgers already imported
+	if k, ok := p.(*parsers.Kraken); ok && resp.Success {
+		s, _ := env.session(r)
+		if err := env.loadKrakenLedgers(k, data.AccountID, data.Exchange, s.UserID); err != nil {
+			log.Printf("Error loading Kraken ledgers: %v\n", err)
+			http.Error(w, "Unable to read account files", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// parse the file into Trade records
 	var ts []parsers.Trade
-	cr := csv.NewReader(strings.NewReader(string(ba)))
-	p, err := parsers.NewParser(data.Exchange)
-	if err != nil {
-		resp.Success = false
-		resp.Message = fmt.Sprintf("File does not match %v format.", data.Exchange)
+	if resp.Success {
+		cr := csv.NewReader(strings.NewReader(string(ba)))
+		res, err := parse(p.(Parser), cr)
+		if err != nil {
+			resp.Success = false
+			resp.Message = fmt.Sprintf("Unable to process exchange file: %v", err)
+		} else {
+			ts = res.Trades
+			resp.Skipped = res.Skipped
+			resp.Failed = res.Failed
+
+			// don't import part of a file, it would skew the balances
+			if len(res.Failed) > 0 {
+				resp.Success = false
+				resp.Message = fmt.Sprintf("%v values could not be read, fix them and upload again.", len(res.Failed))
+			} else if len(ts) == 0 {
+				resp.Success = false
+				resp.Message = "No trades found in file."
+			}
+		}
 	}
 
-	ts, err = parse(p.(Parser), cr)
-	if err != nil {
-		resp.Success = false
-		resp.Message = "Unable to process exchange file."
-	} else {
-		if len(ts) == 0 {
+	s, _ := env.session(r)
+
+	// find the trades already imported from an overlapping file
+	var mts []*models.Trade
+	dups := make(map[int]*models.Trade)
+	if resp.Success {
+		var fps []string
+		for _, t := range ts {
+			mt := &models.Trade{
+				Date:         t.Date,
+				Action:       t.Action,
+				Amount:       t.Amount,
+				Currency:     t.Currency,
+				BaseAmount:   t.BaseAmount,
+				BaseCurrency: t.BaseCurrency,
+				FeeAmount:    t.FeeAmount,
+				FeeCurrency:  t.FeeCurrency,
+				UserID:       s.UserID,
+			}
+			mt.Fingerprint = models.Fingerprint(mt, data.Exchange)
+			mts = append(mts, mt)
+			fps = append(fps, mt.Fingerprint)
+		}
+
+		existing, err := env.db.GetTradesByFingerprint(s.UserID, fps)
+		if err != nil {
+			log.Printf("Error finding duplicate trades: %v\n", err)
+			http.Error(w, "Unable to check for duplicate trades", http.StatusInternalServerError)
+			return
+		}
+
+		// each existing trade matches one incoming trade at most,
+		// a file can legitimately hold identical fills
+		byFP := make(map[string][]*models.Trade)
+		for _, e := range existing {
+			byFP[e.Fingerprint] = append(byFP[e.Fingerprint], e)
+		}
+		for i, mt := range mts {
+			if es := byFP[mt.Fingerprint]; len(es) > 0 {
+				dups[i] = es[0]
+				byFP[mt.Fingerprint] = es[1:]
+
+				d := *mt
+				d.FileID = es[0].FileID
+				resp.Duplicates = append(resp.Duplicates, &d)
+			}
+		}
+
+		if len(dups) > 0 && data.Duplicates == "" {
 			resp.Success = false
-			resp.Message = "No trades found in file."
+			resp.Message = fmt.Sprintf("%v of %v trades already exist in your files. Skip them, merge them, or keep both?", len(dups), len(mts))
+			resp.Exchange = data.Exchange
 		}
 	}
 
+	// transaction for db inserts
+	var tx *models.DB
 	if resp.Success {
-		// transaction for db inserts
-		tx := env.db.BeginTransaction()
+		tx = env.db.BeginTransaction()
 
 		if tx.Error != nil {
 			log.Println("Error starting transaction")
@@ -362,14 +506,27 @@ func (env *Env) postUploadAsync(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s, _ := env.session(r)
+		// the account holding the file
+		var a *models.Account
+		if data.AccountID > 0 {
+			a, err = tx.GetAccount(data.AccountID, s.UserID)
+		} else {
+			a, err = tx.AccountNamed(data.Exchange, "exchange", s.UserID)
+		}
+		if err != nil {
+			tx.Rollback()
+			http.Error(w, "Invalid account", http.StatusBadRequest)
+			return
+		}
+		resp.AccountID = a.ID
 
 		// store the File
 		fs := &models.File{
-			Name:   fileName,
-			Source: data.Exchange,
-			Bytes:  ba,
-			UserID: s.UserID,
+			Name:      fileName,
+			Source:    data.Exchange,
+			Bytes:     ba,
+			AccountID: a.ID,
+			UserID:    s.UserID,
 		}
 		fid, err := tx.SaveFile(fs)
 		if err != nil {
@@ -386,21 +543,30 @@ func (env *Env) postUploadAsync(w http.ResponseWriter, r *http.Request) {
 		} else {
 			resp.FileID = fid
 		}
+	}
 
+	if resp.Success {
 		// store the Trades
-		for _, t := range ts {
-			trade := &models.Trade{
-				Date:         t.Date,
-				Action:       t.Action,
-				Amount:       t.Amount,
-				Currency:     t.Currency,
-				BaseAmount:   t.BaseAmount,
-				BaseCurrency: t.BaseCurrency,
-				FeeAmount:    t.FeeAmount,
-				FeeCurrency:  t.FeeCurrency,
-				FileID:       fid,
-				UserID:       s.UserID,
+		for i, trade := range mts {
+			trade.FileID = resp.FileID
+			trade.AccountID = resp.AccountID
+
+			if e, ok := dups[i]; ok {
+				switch data.Duplicates {
+				case "skip":
+					continue
+				case "merge":
+					// keep the existing trade, now belonging to this file
+					if err := tx.MergeTrade(e.ID, s.UserID, trade); err != nil {
+						tx.Rollback()
+						log.Printf("Error merging trade: %v, error: %v\n", e.ID, err)
+						http.Error(w, "Unable to save trades", http.StatusInternalServerError)
+						return
+					}
+					continue
+				}
 			}
+
 			_, err := tx.SaveTrade(trade)
 			if err != nil {
 				tx.Rollback()
@@ -423,6 +589,34 @@ func (env *Env) postUploadAsync(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// loadKrakenLedgers reads the ledgers files of the account an upload goes
+// to, the one named like the exchange when none is chosen
+func (env *Env) loadKrakenLedgers(k *parsers.Kraken, aid uint, name string, uid uint) error {
+	if aid == 0 {
+		as, err := env.db.GetAccounts(uid)
+		if err != nil {
+			return err
+		}
+		for _, a := range as {
+			if a.Name == name {
+				aid = a.ID
+			}
+		}
+		if aid == 0 {
+			return nil
+		}
+	}
+
+	fs, err := env.db.GetAccountFiles(aid, uid, name)
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		k.LoadLedgers(csv.NewReader(bytes.NewReader(f.Bytes)))
+	}
+	return nil
+}
+
 func (env *Env) deleteFileAsync(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	s, _ := env.session(r)
@@ -475,47 +669,10 @@ func (env *Env) getFileTradesAsync(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (env *Env) getTrades(w http.ResponseWriter, r *http.Request) {
-	s, _ := env.session(r)
-
-	ts, err := env.db.GetManualTrades(s.UserID)
-	if err != nil {
-		log.Printf("Error getting user trades: %v\n", err)
-		http.Error(w, "Error retrieving files", http.StatusInternalServerError)
-		return
-	}
-
-	pr := &Presenter{
-		LoggedIn:  true,
-		CSRFToken: s.CSRFToken,
-		Data: struct {
-			Trades []*models.Trade
-		}{
-			Trades: ts,
-		},
-	}
-
-	t := pageTemplate(
-		"web/templates/components/trade_manager.html.tmpl",
-		"web/templates/manage_trades.html.tmpl",
-	)
-	t.Execute(w, pr)
-}
-
-func (env *Env) postTradeAsync(w http.ResponseWriter, r *http.Request) {
+func (env *Env) postProfileAsync(w http.ResponseWriter, r *http.Request) {
 	// posted JSON structure
-	type Trade struct {
-		Date         string
-		Action       string
-		Amount       string
-		Currency     string
-		BaseAmount   string
-		BaseCurrency string
-		FeeAmount    string
-		FeeCurrency  string
-	}
 	type Data struct {
-		Trade     *Trade
+		Profile   *models.Profile
 		CSRFToken string
 	}
 	// read request body
@@ -528,7 +685,7 @@ func (env *Env) postTradeAsync(w http.ResponseWriter, r *http.Request) {
 
 	// unmarshal json body into Data
 	var data Data
-	if err = json.Unmarshal(body, &data); err != nil {
+	if err = json.Unmarshal(body, &data); err != nil || data.Profile == nil {
 		log.Printf("unmarshal error: %v\n", err)
 		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
 		return
@@ -542,73 +699,59 @@ func (env *Env) postTradeAsync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// validate trade
-	var date time.Time
-	if date, err = time.Parse("2006-01-02", data.Trade.Date); err != nil {
-		http.Error(w, "Invalid date.", http.StatusBadRequest)
-		return
-	}
-
-	action := strings.ToUpper(data.Trade.Action)
-	// skip if not a buy or sell
-	if action != "BUY" && action != "SELL" {
-		http.Error(w, "Must be BUY or SELL.", http.StatusBadRequest)
-		return
-	}
-
-	var amount decimal.Decimal
-	if amount, err = decimal.NewFromString(data.Trade.Amount); err != nil {
-		http.Error(w, "Invalid amount.", http.StatusBadRequest)
-		return
+	// validate profile
+	in := data.Profile
+	clean := func(v string) string {
+		return html.EscapeString(strings.TrimSpace(v))
+	}
+	p := &models.Profile{
+		Name:               clean(in.Name),
+		DateColumn:         clean(in.DateColumn),
+		DateLayout:         strings.TrimSpace(in.DateLayout),
+		ActionColumn:       clean(in.ActionColumn),
+		BuyWords:           clean(in.BuyWords),
+		SellWords:          clean(in.SellWords),
+		AmountColumn:       clean(in.AmountColumn),
+		CurrencyColumn:     clean(in.CurrencyColumn),
+		BaseAmountColumn:   clean(in.BaseAmountColumn),
+		BaseCurrencyColumn: clean(in.BaseCurrencyColumn),
+		FeeAmountColumn:    clean(in.FeeAmountColumn),
+		FeeCurrencyColumn:  clean(in.FeeCurrencyColumn),
+		UserID:             s.UserID,
+	}
+	for _, v := range []string{p.Name, p.DateColumn, p.ActionColumn, p.AmountColumn, p.CurrencyColumn, p.BaseAmountColumn, p.BaseCurrencyColumn} {
+		if v == "" {
+			http.Error(w, "Name and trade columns are required.", http.StatusBadRequest)
+			return
+		}
 	}
-
-	currency := strings.ToUpper(html.EscapeString(data.Trade.Currency))
-
-	var baseAmount decimal.Decimal
-	if baseAmount, err = decimal.NewFromString(data.Trade.BaseAmount); err != nil {
-		http.Error(w, "Invalid base amount.", http.StatusBadRequest)
+	if len(p.Buys()) == 0 || len(p.Sells()) == 0 {
+		http.Error(w, "Buy and sell words are required.", http.StatusBadRequest)
 		return
 	}
-
-	baseCurrency := strings.ToUpper(html.EscapeString(data.Trade.BaseCurrency))
-
-	var feeAmount decimal.Decimal
-	if feeAmount, err = decimal.NewFromString(data.Trade.FeeAmount); err != nil {
-		http.Error(w, "Invalid base amount.", http.StatusBadRequest)
+	if contains(SupportedExchanges, p.Name) || p.Name == "Auto" {
+		http.Error(w, "Name is already an exchange.", http.StatusBadRequest)
 		return
 	}
 
-	feeCurrency := strings.ToUpper(html.EscapeString(data.Trade.FeeCurrency))
-
-	trd := &models.Trade{
-		Date:         date,
-		Action:       action,
-		Amount:       amount,
-		Currency:     currency,
-		BaseAmount:   baseAmount,
-		BaseCurrency: baseCurrency,
-		FeeAmount:    feeAmount,
-		FeeCurrency:  feeCurrency,
-		UserID:       s.UserID,
-	}
-	t, err := env.db.SaveTrade(trd)
+	p, err = env.db.SaveProfile(p)
 	if err != nil {
-		log.Printf("Error saving trade: %v\n%v\n", trd, err)
-		http.Error(w, "Error saving trade.", http.StatusInternalServerError)
+		log.Printf("Error saving profile: %v\n", err)
+		http.Error(w, "Error saving profile.", http.StatusInternalServerError)
 		return
 	}
 
 	type Response struct {
-		Trade *models.Trade `json:"trade"`
+		Profile *models.Profile `json:"profile"`
 	}
-	resp := &Response{Trade: t}
+	resp := &Response{Profile: p}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (env *Env) deleteTradeAsync(w http.ResponseWriter, r *http.Request) {
+func (env *Env) deleteProfileAsync(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	s, _ := env.session(r)
 
@@ -618,15 +761,14 @@ func (env *Env) deleteTradeAsync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// get query params
 	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid trade id", http.StatusBadRequest)
+		http.Error(w, "Invalid profile id", http.StatusBadRequest)
 		return
 	}
 
-	if err = env.db.DeleteTrade(uint(id), s.UserID); err != nil {
-		http.Error(w, "Unable to delete trade", http.StatusBadRequest)
+	if err = env.db.DeleteProfile(uint(id), s.UserID); err != nil {
+		http.Error(w, "Unable to delete profile", http.StatusBadRequest)
 		return
 	}
 
@@ -635,77 +777,11 @@ func (env *Env) deleteTradeAsync(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode("")
 }
 
-func (env *Env) getReports(w http.ResponseWriter, r *http.Request) {
-	s, _ := env.session(r)
-
-	pr := &Presenter{
-		LoggedIn:  true,
-		CSRFToken: s.CSRFToken,
-	}
-
-	t := pageTemplate(
-		"web/templates/reports.html.tmpl",
-		"web/templates/components/report_viewer.html.tmpl",
-	)
-	t.Execute(w, pr)
-}
-
-func (env *Env) getRateRequestAsync(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	s, _ := env.session(r)
-
-	// verify CSRF token
-	if q.Get("csrf_token") != s.CSRFToken {
-		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
-		return
-	}
-
-	// get query params
-	t := q.Get("type")
-	if t != "Holdings" && t != "ACB" {
-		http.Error(w, "Invalid report type", http.StatusBadRequest)
-		return
-	}
-
-	c := q.Get("currency")
-	if c != "CAD" {
-		http.Error(w, "Invalid currency", http.StatusBadRequest)
-		return
-	}
-
-	a := q.Get("asof")
-	if a != "Today" && a != "EOY2017" {
-		http.Error(w, "Invalid as of", http.StatusBadRequest)
-		return
-	}
-
-	ts, err := env.db.GetUserTrades(s.UserID)
-	if err != nil {
-		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
-		return
-	}
-
-	// get rate requests
-	rr, _ := reports.Analyze(ts, c)
-
-	type Response struct {
-		Items []*reports.RateRequest `json:"items"`
-		Error string                 `json:"error"`
-	}
-	resp := &Response{Items: rr}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(resp)
-}
-
-func (env *Env) postReportAsync(w http.ResponseWriter, r *http.Request) {
+func (env *Env) postAccountAsync(w http.ResponseWriter, r *http.Request) {
 	// posted JSON structure
 	type Data struct {
-		Type      string                 `json:"type"` // to be used by ACB report
-		Currency  string                 `json:"currency"`
-		AsOf      string                 `json:"asof"` // to be used by ACB report
-		Rates     []*reports.RateRequest `json:"rates"`
+		Name      string
+		Kind      string
 		CSRFToken string
 	}
 	// read request body
@@ -730,28 +806,1154 @@ func (env *Env) postReportAsync(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	name := html.EscapeString(strings.TrimSpace(data.Name))
+	if name == "" {
+		http.Error(w, "Name missing.", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidAccountKind(data.Kind) {
+		http.Error(w, "Must be an exchange or a wallet.", http.StatusBadRequest)
+		return
+	}
+
 	s, _ := env.session(r)
-	ts, err := env.db.GetUserTrades(s.UserID)
+	a, err := env.db.SaveAccount(&models.Account{
+		Name:   name,
+		Kind:   data.Kind,
+		UserID: s.UserID,
+	})
 	if err != nil {
-		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
+		if err, ok := err.(*pq.Error); ok && err.Code.Name() == "unique_violation" {
+			http.Error(w, "Account already exists.", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error saving account: %v\n", err)
+		http.Error(w, "Error saving account.", http.StatusInternalServerError)
 		return
 	}
 
-	type Item struct {
-		Asset  string          `json:"asset"`
-		Amount decimal.Decimal `json:"amount"`
-		ACB    decimal.Decimal `json:"acb"`
-		Value  decimal.Decimal `json:"value"`
-		Gain   decimal.Decimal `json:"gain"`
-	}
 	type Response struct {
-		Items []*Item `json:"items"`
-		Error string  `json:"error"`
+		Account *models.Account `json:"account"`
+	}
+	resp := &Response{Account: a}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (env *Env) deleteAccountAsync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if q.Get("csrf_token") != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// get query params
+	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account id", http.StatusBadRequest)
+		return
+	}
+
+	// accounts still holding files, trades or transfers are kept
+	if err = env.db.DeleteAccount(uint(id), s.UserID); err != nil {
+		http.Error(w, "Unable to delete account, it is in use", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("")
+}
+
+// postFileAccountAsync moves a file and its trades to another account
+func (env *Env) postFileAccountAsync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if q.Get("csrf_token") != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// get query params
+	fid, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file id", http.StatusBadRequest)
+		return
+	}
+	aid, err := strconv.ParseUint(q.Get("account"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid account id", http.StatusBadRequest)
+		return
+	}
+
+	tx := env.db.BeginTransaction()
+	if tx.Error != nil {
+		log.Println("Error starting transaction")
+		http.Error(w, "Failed to begin transaction", http.StatusInternalServerError)
+		return
+	}
+	if err = tx.MoveFile(uint(fid), uint(aid), s.UserID); err != nil {
+		tx.Rollback()
+		http.Error(w, "Unable to move file", http.StatusBadRequest)
+		return
+	}
+	tx.Commit()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("")
+}
+
+func (env *Env) getTrades(w http.ResponseWriter, r *http.Request) {
+	s, _ := env.session(r)
+
+	ts, err := env.db.GetManualTrades(s.UserID)
+	if err != nil {
+		log.Printf("Error getting user trades: %v\n", err)
+		http.Error(w, "Error retrieving files", http.StatusInternalServerError)
+		return
+	}
+
+	as, err := env.db.GetAccounts(s.UserID)
+	if err != nil {
+		log.Printf("Error getting user accounts: %v\n", err)
+		http.Error(w, "Error retrieving accounts", http.StatusInternalServerError)
+		return
+	}
+
+	pr := &Presenter{
+		LoggedIn:  true,
+		CSRFToken: s.CSRFToken,
+		Data: struct {
+			Accounts []*models.Account
+			Trades   []*models.Trade
+		}{
+			Accounts: as,
+			Trades:   ts,
+		},
+	}
+
+	t := pageTemplate(
+		"web/templates/components/trade_manager.html.tmpl",
+		"web/templates/manage_trades.html.tmpl",
+	)
+	t.Execute(w, pr)
+}
+
+func (env *Env) postTradeAsync(w http.ResponseWriter, r *http.Request) {
+	// posted JSON structure
+	type Trade struct {
+		Date         string
+		Action       string
+		Amount       string
+		Currency     string
+		BaseAmount   string
+		BaseCurrency string
+		FeeAmount    string
+		FeeCurrency  string
+		AccountID    uint
+	}
+	type Data struct {
+		Trade     *Trade
+		CSRFToken string
+	}
+	// read request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v\n", err)
+		http.Error(w, "Error reading request", http.StatusInternalServerError)
+		return
+	}
+
+	// unmarshal json body into Data
+	var data Data
+	if err = json.Unmarshal(body, &data); err != nil {
+		log.Printf("unmarshal error: %v\n", err)
+		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
+		return
+	}
+
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if data.CSRFToken != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// validate trade
+	var date time.Time
+	if date, err = time.Parse("2006-01-02", data.Trade.Date); err != nil {
+		http.Error(w, "Invalid date.", http.StatusBadRequest)
+		return
+	}
+
+	action := strings.ToUpper(data.Trade.Action)
+	// skip if not a buy, sell or income
+	if action != "BUY" && action != "SELL" && !models.IsIncome(action) {
+		http.Error(w, "Must be BUY, SELL or income.", http.StatusBadRequest)
+		return
+	}
+
+	var amount decimal.Decimal
+	if amount, err = decimal.NewFromString(data.Trade.Amount); err != nil {
+		http.Error(w, "Invalid amount.", http.StatusBadRequest)
+		return
+	}
+
+	currency := strings.ToUpper(html.EscapeString(data.Trade.Currency))
+
+	var baseAmount decimal.Decimal
+	if baseAmount, err = decimal.NewFromString(data.Trade.BaseAmount); err != nil {
+		http.Error(w, "Invalid base amount.", http.StatusBadRequest)
+		return
+	}
+
+	baseCurrency := strings.ToUpper(html.EscapeString(data.Trade.BaseCurrency))
+
+	var feeAmount decimal.Decimal
+	if feeAmount, err = decimal.NewFromString(data.Trade.FeeAmount); err != nil {
+		http.Error(w, "Invalid base amount.", http.StatusBadRequest)
+		return
+	}
+
+	feeCurrency := strings.ToUpper(html.EscapeString(data.Trade.FeeCurrency))
+
+	// held in a catch-all wallet unless an account is chosen
+	var a *models.Account
+	if data.Trade.AccountID > 0 {
+		a, err = env.db.GetAccount(data.Trade.AccountID, s.UserID)
+	} else {
+		a, err = env.db.AccountNamed("Other", "wallet", s.UserID)
+	}
+	if err != nil {
+		http.Error(w, "Invalid account.", http.StatusBadRequest)
+		return
+	}
+
+	trd := &models.Trade{
+		Date:         date,
+		Action:       action,
+		Amount:       amount,
+		Currency:     currency,
+		BaseAmount:   baseAmount,
+		BaseCurrency: baseCurrency,
+		FeeAmount:    feeAmount,
+		FeeCurrency:  feeCurrency,
+		AccountID:    a.ID,
+		UserID:       s.UserID,
+	}
+	t, err := env.db.SaveTrade(trd)
+	if err != nil {
+		log.Printf("Error saving trade: %v\n%v\n", trd, err)
+		http.Error(w, "Error saving trade.", http.StatusInternalServerError)
+		return
+	}
+
+	type Response struct {
+		Trade *models.Trade `json:"trade"`
+	}
+	resp := &Response{Trade: t}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (env *Env) deleteTradeAsync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if q.Get("csrf_token") != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// get query params
+	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid trade id", http.StatusBadRequest)
+		return
+	}
+
+	if err = env.db.DeleteTrade(uint(id), s.UserID); err != nil {
+		http.Error(w, "Unable to delete trade", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("")
+}
+
+func (env *Env) getTransfers(w http.ResponseWriter, r *http.Request) {
+	s, _ := env.session(r)
+
+	ts, err := env.db.GetTransfers(s.UserID)
+	if err != nil {
+		log.Printf("Error getting user transfers: %v\n", err)
+		http.Error(w, "Error retrieving transfers", http.StatusInternalServerError)
+		return
+	}
+
+	as, err := env.db.GetAccounts(s.UserID)
+	if err != nil {
+		log.Printf("Error getting user accounts: %v\n", err)
+		http.Error(w, "Error retrieving accounts", http.StatusInternalServerError)
+		return
+	}
+
+	pr := &Presenter{
+		LoggedIn:  true,
+		CSRFToken: s.CSRFToken,
+		Data: struct {
+			Accounts  []*models.Account
+			Transfers []*models.Transfer
+		}{
+			Accounts:  as,
+			Transfers: ts,
+		},
+	}
+
+	t := pageTemplate(
+		"web/templates/components/transfer_manager.html.tmpl",
+		"web/templates/manage_transfers.html.tmpl",
+	)
+	t.Execute(w, pr)
+}
+
+func (env *Env) postTransferAsync(w http.ResponseWriter, r *http.Request) {
+	// posted JSON structure
+	type Transfer struct {
+		Date          string
+		Asset         string
+		Amount        string
+		FromAccountID uint
+		ToAccountID   uint
+		FeeAmount     string
+		TxHash        string
+	}
+	type Data struct {
+		Transfer  *Transfer
+		CSRFToken string
+	}
+	// read request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v\n", err)
+		http.Error(w, "Error reading request", http.StatusInternalServerError)
+		return
+	}
+
+	// unmarshal json body into Data
+	var data Data
+	if err = json.Unmarshal(body, &data); err != nil || data.Transfer == nil {
+		log.Printf("unmarshal error: %v\n", err)
+		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
+		return
+	}
+
+	// verify CSRF token
+	if !env.validToken(r, data.CSRFToken) {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// validate transfer
+	var date time.Time
+	if date, err = time.Parse("2006-01-02", data.Transfer.Date); err != nil {
+		http.Error(w, "Invalid date.", http.StatusBadRequest)
+		return
+	}
+
+	asset := strings.ToUpper(html.EscapeString(strings.TrimSpace(data.Transfer.Asset)))
+	if asset == "" {
+		http.Error(w, "Asset missing.", http.StatusBadRequest)
+		return
+	}
+
+	var amount decimal.Decimal
+	if amount, err = decimal.NewFromString(data.Transfer.Amount); err != nil || !amount.IsPositive() {
+		http.Error(w, "Invalid amount.", http.StatusBadRequest)
+		return
+	}
+
+	fee := decimal.NewFromFloat(0)
+	if data.Transfer.FeeAmount != "" {
+		if fee, err = decimal.NewFromString(data.Transfer.FeeAmount); err != nil || fee.IsNegative() {
+			http.Error(w, "Invalid network fee.", http.StatusBadRequest)
+			return
+		}
+	}
+
+	s, _ := env.session(r)
+
+	from, to := data.Transfer.FromAccountID, data.Transfer.ToAccountID
+	if from == to {
+		http.Error(w, "Must be between two accounts.", http.StatusBadRequest)
+		return
+	}
+	for _, id := range []uint{from, to} {
+		if _, err := env.db.GetAccount(id, s.UserID); err != nil {
+			http.Error(w, "Invalid account.", http.StatusBadRequest)
+			return
+		}
+	}
+	t, err := env.db.SaveTransfer(&models.Transfer{
+		Date:          date,
+		Asset:         asset,
+		Amount:        amount,
+		FromAccountID: from,
+		ToAccountID:   to,
+		FeeAmount:     fee,
+		TxHash:        html.EscapeString(strings.TrimSpace(data.Transfer.TxHash)),
+		UserID:        s.UserID,
+	})
+	if err != nil {
+		log.Printf("Error saving transfer: %v\n", err)
+		http.Error(w, "Error saving transfer.", http.StatusInternalServerError)
+		return
+	}
+
+	type Response struct {
+		Transfer *models.Transfer `json:"transfer"`
+	}
+	resp := &Response{Transfer: t}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// postMatchTransfersAsync pairs the imported withdrawals and deposits
+func (env *Env) postMatchTransfersAsync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if q.Get("csrf_token") != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	ts, err := env.db.GetUserTrades(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
+		return
+	}
+	existing, err := env.db.GetTransfers(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user transfers", http.StatusInternalServerError)
+		return
+	}
+	type Response struct {
+		Transfers []*models.Transfer `json:"transfers"`
+	}
+	resp := &Response{}
+
+	for _, t := range transfers.Match(ts, existing) {
+		t, err := env.db.SaveTransfer(t)
+		if err != nil {
+			log.Printf("Error saving transfer: %v\n", err)
+			http.Error(w, "Error saving transfers", http.StatusInternalServerError)
+			return
+		}
+		resp.Transfers = append(resp.Transfers, t)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (env *Env) deleteTransferAsync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if q.Get("csrf_token") != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// get query params
+	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid transfer id", http.StatusBadRequest)
+		return
+	}
+
+	if err = env.db.DeleteTransfer(uint(id), s.UserID); err != nil {
+		http.Error(w, "Unable to delete transfer", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("")
+}
+
+// postForm8949 downloads the sales of a tax year in Form 8949 layout
+func (env *Env) postForm8949(w http.ResponseWriter, r *http.Request) {
+	// posted JSON structure
+	type Data struct {
+		Currency  string `json:"currency"`
+		Method    string `json:"method"`
+		Year      int    `json:"year"`
+		Rates     string `json:"rates"` // granularity
+		CSRFToken string
+	}
+	// read request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v\n", err)
+		http.Error(w, "Error reading request", http.StatusInternalServerError)
+		return
+	}
+
+	// unmarshal json body into Data
+	var data Data
+	if err = json.Unmarshal(body, &data); err != nil {
+		log.Printf("unmarshal error: %v\n", err)
+		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
+		return
+	}
+
+	// verify CSRF token
+	if !env.validToken(r, data.CSRFToken) {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	if !contains(SupportedCurrencies, data.Currency) {
+		http.Error(w, "Invalid currency", http.StatusBadRequest)
+		return
+	}
+	if data.Method == "" || data.Method == reports.AverageCost || !reports.ValidMethod(data.Method) {
+		http.Error(w, "Form 8949 needs FIFO, LIFO, HIFO or specific ID.", http.StatusBadRequest)
+		return
+	}
+	g, ok := granularity(data.Rates)
+	if !ok {
+		http.Error(w, "Invalid rates", http.StatusBadRequest)
+		return
+	}
+
+	s, _ := env.session(r)
+	ts, err := env.db.GetUserTrades(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
+		return
+	}
+	trs, err := env.db.GetTransfers(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user transfers", http.StatusInternalServerError)
+		return
+	}
+	var sel []*models.LotSelection
+	if data.Method == reports.SpecificID {
+		if sel, err = env.db.GetLotSelections(s.UserID); err != nil {
+			http.Error(w, "Error getting lot selections", http.StatusInternalServerError)
+			return
+		}
+	}
+	// network fees are dispositions
+	ts = append(ts, reports.TransferFees(trs)...)
+
+	rates, err := resolveRates(ts, data.Currency, time.Time{}, g)
+	if err != nil {
+		log.Printf("Resolve rates error: %v", err)
+		http.Error(w, "Error getting exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	f := &reports.Form8949{Currency: data.Currency, Method: data.Method, Selections: sel, Year: data.Year}
+	if err = f.Build(ts, reportConverter(ts, rates)); err != nil {
+		switch err.(type) {
+		case *reports.Oversold:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("Build report error: %v", err)
+			http.Error(w, "Error building report", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	csv, err := f.CSV()
+	if err != nil {
+		http.Error(w, "Error generating CSV", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=cryptotax-8949-%v.csv", data.Year))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Write(csv)
+}
+
+// postSchedule3 downloads the sales of a tax year for CRA Schedule 3
+func (env *Env) postSchedule3(w http.ResponseWriter, r *http.Request) {
+	// posted JSON structure
+	type Data struct {
+		Currency  string `json:"currency"`
+		Year      int    `json:"year"`
+		Rates     string `json:"rates"` // granularity
+		CSRFToken string
+	}
+	// read request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v\n", err)
+		http.Error(w, "Error reading request", http.StatusInternalServerError)
+		return
+	}
+
+	// unmarshal json body into Data
+	var data Data
+	if err = json.Unmarshal(body, &data); err != nil {
+		log.Printf("unmarshal error: %v\n", err)
+		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
+		return
+	}
+
+	// verify CSRF token
+	if !env.validToken(r, data.CSRFToken) {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	if !contains(SupportedCurrencies, data.Currency) || data.Year == 0 {
+		http.Error(w, "Invalid currency or year", http.StatusBadRequest)
+		return
+	}
+	g, ok := granularity(data.Rates)
+	if !ok {
+		http.Error(w, "Invalid rates", http.StatusBadRequest)
+		return
+	}
+
+	s, _ := env.session(r)
+	ts, err := env.db.GetUserTrades(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
+		return
+	}
+	trs, err := env.db.GetTransfers(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user transfers", http.StatusInternalServerError)
+		return
+	}
+	// network fees are dispositions
+	ts = append(ts, reports.TransferFees(trs)...)
+
+	rates, err := resolveRates(ts, data.Currency, time.Time{}, g)
+	if err != nil {
+		log.Printf("Resolve rates error: %v", err)
+		http.Error(w, "Error getting exchange rates", http.StatusInternalServerError)
+		return
+	}
+
+	s3 := &reports.Schedule3{Currency: data.Currency, Year: data.Year}
+	if err = s3.Build(ts, reportConverter(ts, rates)); err != nil {
+		switch err.(type) {
+		case *reports.Oversold:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			log.Printf("Build report error: %v", err)
+			http.Error(w, "Error building report", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	csv, err := s3.CSV()
+	if err != nil {
+		http.Error(w, "Error generating CSV", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=cryptotax-schedule3-%v.csv", data.Year))
+	w.Header().Set("Content-Type", "text/csv")
+	w.Write(csv)
+}
+
+// postLotSelectionAsync picks a lot to sell from, for specific identification
+func (env *Env) postLotSelectionAsync(w http.ResponseWriter, r *http.Request) {
+	// posted JSON structure
+	type Data struct {
+		SaleID    uint
+		LotID     uint
+		Amount    string
+		CSRFToken string
+	}
+	// read request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v\n", err)
+		http.Error(w, "Error reading request", http.StatusInternalServerError)
+		return
+	}
+
+	// unmarshal json body into Data
+	var data Data
+	if err = json.Unmarshal(body, &data); err != nil {
+		log.Printf("unmarshal error: %v\n", err)
+		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
+		return
+	}
+
+	// verify CSRF token
+	if !env.validToken(r, data.CSRFToken) {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	var amount decimal.Decimal
+	if amount, err = decimal.NewFromString(data.Amount); err != nil || !amount.IsPositive() {
+		http.Error(w, "Invalid amount.", http.StatusBadRequest)
+		return
+	}
+
+	s, _ := env.session(r)
+	ts, err := env.db.GetUserTrades(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
+		return
+	}
+	var sale, lot *models.Trade
+	for _, t := range ts {
+		if t.ID == data.SaleID {
+			sale = t
+		}
+		if t.ID == data.LotID {
+			lot = t
+		}
+	}
+	if sale == nil || lot == nil {
+		http.Error(w, "Invalid trade.", http.StatusBadRequest)
+		return
+	}
+
+	// the asset the sale gave up, that the lot acquired
+	asset := sale.Currency
+	if sale.Action == "BUY" {
+		asset = sale.BaseCurrency
+	}
+	acquired := lot.Currency
+	if lot.Action == "SELL" {
+		acquired = lot.BaseCurrency
+	}
+	if asset != acquired || lot.Date.After(sale.Date) {
+		http.Error(w, "Lot must be the same asset, acquired before the sale.", http.StatusBadRequest)
+		return
+	}
+
+	ls, err := env.db.SaveLotSelection(&models.LotSelection{
+		SaleID: sale.ID,
+		LotID:  lot.ID,
+		Asset:  asset,
+		Amount: amount,
+		UserID: s.UserID,
+	})
+	if err != nil {
+		log.Printf("Error saving lot selection: %v\n", err)
+		http.Error(w, "Error saving lot selection.", http.StatusInternalServerError)
+		return
+	}
+
+	type Response struct {
+		Selection *models.LotSelection `json:"selection"`
+	}
+	resp := &Response{Selection: ls}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (env *Env) deleteLotSelectionAsync(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s, _ := env.session(r)
+
+	// verify CSRF token
+	if q.Get("csrf_token") != s.CSRFToken {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	// get query params
+	id, err := strconv.ParseUint(q.Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid lot selection id", http.StatusBadRequest)
+		return
+	}
+
+	if err = env.db.DeleteLotSelection(uint(id), s.UserID); err != nil {
+		http.Error(w, "Unable to delete lot selection", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode("")
+}
+
+func (env *Env) getReports(w http.ResponseWriter, r *http.Request) {
+	s, _ := env.session(r)
+
+	pr := &Presenter{
+		LoggedIn:  true,
+		CSRFToken: s.CSRFToken,
+	}
+
+	t := pageTemplate(
+		"web/templates/reports.html.tmpl",
+		"web/templates/components/report_viewer.html.tmpl",
+	)
+	t.Execute(w, pr)
+}
+
+func (env *Env) postReportAsync(w http.ResponseWriter, r *http.Request) {
+	// posted JSON structure
+	type Data struct {
+		Type      string `json:"type"` // to be used by ACB report
+		Currency  string `json:"currency"`
+		AsOf      string `json:"asof"`
+		Method    string `json:"method"` // cost basis method
+		Rates     string `json:"rates"`  // granularity
+		CSRFToken string
+	}
+	// read request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body: %v\n", err)
+		http.Error(w, "Error reading request", http.StatusInternalServerError)
+		return
+	}
+
+	// unmarshal json body into Data
+	var data Data
+	if err = json.Unmarshal(body, &data); err != nil {
+		log.Printf("unmarshal error: %v\n", err)
+		http.Error(w, "Error during JSON unmarshal", http.StatusBadRequest)
+		return
+	}
+
+	// verify CSRF token
+	if !env.validToken(r, data.CSRFToken) {
+		http.Error(w, "Invalid CSRF token", http.StatusBadRequest)
+		return
+	}
+
+	if data.Type != "Holdings" && data.Type != "ACB" && data.Type != "Income" && data.Type != "Gains" && data.Type != "Schedule3" {
+		http.Error(w, "Invalid report type", http.StatusBadRequest)
+		return
+	}
+	if !contains(SupportedCurrencies, data.Currency) {
+		http.Error(w, "Invalid currency", http.StatusBadRequest)
+		return
+	}
+	if !reports.ValidMethod(data.Method) {
+		http.Error(w, "Invalid cost basis method", http.StatusBadRequest)
+		return
+	}
+	g, ok := granularity(data.Rates)
+	if !ok {
+		http.Error(w, "Invalid rates", http.StatusBadRequest)
+		return
+	}
+
+	from, asOf, err := reports.ParseAsOf(data.AsOf, time.Now())
+	if err != nil {
+		http.Error(w, "Invalid as of", http.StatusBadRequest)
+		return
+	}
+
+	s, _ := env.session(r)
+	ts, err := env.db.GetUserTrades(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user trades", http.StatusInternalServerError)
+		return
+	}
+	trs, err := env.db.GetTransfers(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user transfers", http.StatusInternalServerError)
+		return
+	}
+	var sel []*models.LotSelection
+	if data.Method == reports.SpecificID {
+		if sel, err = env.db.GetLotSelections(s.UserID); err != nil {
+			http.Error(w, "Error getting lot selections", http.StatusInternalServerError)
+			return
+		}
+	}
+	// network fees are dispositions
+	ts = append(ts, reports.TransferFees(trs)...)
+
+	// the trades after don't change a report as of a date, other than
+	// the yearly gains which cover all of them
+	rated := ts
+	if data.Type != "Gains" && data.Type != "Schedule3" {
+		rated = nil
+		for _, t := range ts {
+			if !t.Date.After(asOf) {
+				rated = append(rated, t)
+			}
+		}
+	}
+	var value time.Time
+	if data.Type == "Holdings" {
+		value = asOf
+	}
+	rates, err := resolveRates(rated, data.Currency, value, g)
+	if err != nil {
+		log.Printf("Resolve rates error: %v", err)
+		http.Error(w, "Error getting exchange rates", http.StatusInternalServerError)
+		return
+	}
+	conv := reportConverter(ts, rates)
+
+	type Item struct {
+		Asset   string          `json:"asset"`
+		Amount  decimal.Decimal `json:"amount"`
+		ACB     decimal.Decimal `json:"acb"`
+		Value   decimal.Decimal `json:"value"`
+		Gain    decimal.Decimal `json:"gain"`
+		Percent decimal.Decimal `json:"percent"`
+	}
+	type AccountItem struct {
+		Account string          `json:"account"`
+		Asset   string          `json:"asset"`
+		Amount  decimal.Decimal `json:"amount"`
+	}
+	type IncomeItem struct {
+		Date   time.Time       `json:"date"`
+		Kind   string          `json:"kind"`
+		Asset  string          `json:"asset"`
+		Amount decimal.Decimal `json:"amount"`
+		Value  decimal.Decimal `json:"value"`
+	}
+	type Superficial struct {
+		Date   time.Time       `json:"date"`
+		Asset  string          `json:"asset"`
+		Amount decimal.Decimal `json:"amount"`
+		Denied decimal.Decimal `json:"denied"`
+	}
+	type Unmatched struct {
+		TradeID uint            `json:"tradeId"`
+		Date    time.Time       `json:"date"`
+		Action  string          `json:"action"`
+		Asset   string          `json:"asset"`
+		Amount  decimal.Decimal `json:"amount"`
+	}
+	type Sale struct {
+		SaleID   uint            `json:"saleId"`
+		LotID    uint            `json:"lotId"`
+		Acquired time.Time       `json:"acquired"`
+		Term     string          `json:"term"`
+		Date     time.Time       `json:"date"`
+		Asset    string          `json:"asset"`
+		Amount   decimal.Decimal `json:"amount"`
+		Proceeds decimal.Decimal `json:"proceeds"`
+		ACB      decimal.Decimal `json:"acb"`
+		Gain     decimal.Decimal `json:"gain"`
+	}
+	type Lot struct {
+		LotID  uint            `json:"lotId"`
+		Date   time.Time       `json:"date"`
+		Asset  string          `json:"asset"`
+		Amount decimal.Decimal `json:"amount"`
+		Cost   decimal.Decimal `json:"cost"`
+	}
+	type Term struct {
+		Proceeds decimal.Decimal `json:"proceeds"`
+		Cost     decimal.Decimal `json:"cost"`
+		Gain     decimal.Decimal `json:"gain"`
+	}
+	type GainsYear struct {
+		Year      int   `json:"year"`
+		ShortTerm *Term `json:"shortTerm"`
+		LongTerm  *Term `json:"longTerm"`
+	}
+	type Schedule3Year struct {
+		Year     int             `json:"year"`
+		Proceeds decimal.Decimal `json:"proceeds"`
+		ACB      decimal.Decimal `json:"acb"`
+		Expenses decimal.Decimal `json:"expenses"`
+		Gain     decimal.Decimal `json:"gain"`
+		Taxable  decimal.Decimal `json:"taxable"`
+	}
+	type ACBItem struct {
+		Date        time.Time       `json:"date"`
+		Asset       string          `json:"asset"`
+		Acquired    int             `json:"acquired"`
+		Amount      decimal.Decimal `json:"amount"`
+		Proceeds    decimal.Decimal `json:"proceeds"`
+		ACB         decimal.Decimal `json:"acb"`
+		Expenses    decimal.Decimal `json:"expenses"`
+		Superficial decimal.Decimal `json:"superficial"`
+		NetIncome   decimal.Decimal `json:"netIncome"`
+		Gain        decimal.Decimal `json:"gain"`
+	}
+	type Response struct {
+		Items       []*Item                `json:"items"`
+		ACB         []*ACBItem             `json:"acb"`
+		Gains       []*GainsYear           `json:"gains"`
+		Schedule3   []*Schedule3Year       `json:"schedule3"`
+		Accounts    []*AccountItem         `json:"accounts"`
+		Income      []*IncomeItem          `json:"income"`
+		IncomeTotal decimal.Decimal        `json:"incomeTotal"`
+		Realized    decimal.Decimal        `json:"realized"` // in the year as of
+		Superficial []*Superficial         `json:"superficial"`
+		Unmatched   []*Unmatched           `json:"unmatched"`  // sent or received, without a transfer
+		Sales       []*Sale                `json:"sales"`      // by lot
+		Lots        []*Lot                 `json:"lots"`       // left
+		Selections  []*models.LotSelection `json:"selections"` // specific identification
+		Error       string                 `json:"error"`
 	}
 	resp := &Response{}
 
-	rpt := &reports.Holdings{Currency: data.Currency}
-	err = rpt.Build(ts, rateConverter(data.Rates))
+	// a coin sent without a transfer may be a payment or a gift, to be
+	// classified by the user
+	unmatched := func(ts []*models.Trade, trs []*models.Transfer, asOf time.Time) (us []*Unmatched) {
+		for _, t := range reports.Unmatched(ts, trs, asOf) {
+			us = append(us, &Unmatched{
+				TradeID: t.ID,
+				Date:    t.Date,
+				Action:  t.Action,
+				Asset:   t.Currency,
+				Amount:  t.Amount,
+			})
+		}
+		return
+	}
+
+	if data.Type == "Income" {
+		inc := &reports.Income{Currency: data.Currency, From: from, To: asOf}
+		if err = inc.Build(ts, conv); err != nil {
+			log.Printf("Build report error: %v", err)
+			http.Error(w, "Error building report", http.StatusInternalServerError)
+			return
+		}
+		for _, i := range inc.Items {
+			resp.Income = append(resp.Income, &IncomeItem{
+				Date:   i.Date,
+				Kind:   i.Kind,
+				Asset:  i.Asset,
+				Amount: i.Amount,
+				Value:  i.Value,
+			})
+		}
+		resp.IncomeTotal = inc.Total
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if data.Type == "ACB" {
+		rpt := &reports.ACB{Currency: data.Currency, From: from, AsOf: asOf}
+		if err = rpt.Build(ts, conv); err != nil {
+			switch err.(type) {
+			case *reports.Oversold:
+				resp.Error = err.Error()
+			default:
+				log.Printf("Build report error: %v", err)
+				http.Error(w, "Error building report", http.StatusInternalServerError)
+				return
+			}
+		}
+		for _, i := range rpt.Items {
+			resp.ACB = append(resp.ACB, &ACBItem{
+				Date:        i.Date,
+				Asset:       i.Asset,
+				Acquired:    i.Acquired,
+				Amount:      i.Amount,
+				Proceeds:    i.Proceeds,
+				ACB:         i.ACB,
+				Expenses:    i.Expenses,
+				Superficial: i.Superficial,
+				NetIncome:   i.NetIncome,
+				Gain:        i.Gain,
+			})
+		}
+		resp.Realized = rpt.Total
+		resp.Unmatched = unmatched(ts, trs, asOf)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if data.Type == "Schedule3" {
+		s3 := &reports.Schedule3{Currency: data.Currency}
+		if err = s3.Build(ts, conv); err != nil {
+			switch err.(type) {
+			case *reports.Oversold:
+				resp.Error = err.Error()
+			default:
+				log.Printf("Build report error: %v", err)
+				http.Error(w, "Error building report", http.StatusInternalServerError)
+				return
+			}
+		}
+		for _, t := range s3.Totals() {
+			resp.Schedule3 = append(resp.Schedule3, &Schedule3Year{
+				Year:     t.Year,
+				Proceeds: t.Proceeds,
+				ACB:      t.ACB,
+				Expenses: t.Expenses,
+				Gain:     t.Gain,
+				Taxable:  t.Taxable,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if data.Type == "Gains" {
+		if data.Method == "" || data.Method == reports.AverageCost {
+			resp.Error = "Short and long term gains need FIFO, LIFO, HIFO or specific ID."
+		} else {
+			g := &reports.Gains{Currency: data.Currency, Method: data.Method, Selections: sel}
+			if err = g.Build(ts, conv); err != nil {
+				switch err.(type) {
+				case *reports.Oversold:
+					resp.Error = err.Error()
+				default:
+					log.Printf("Build report error: %v", err)
+					http.Error(w, "Error building report", http.StatusInternalServerError)
+					return
+				}
+			}
+			for _, y := range g.Years {
+				resp.Gains = append(resp.Gains, &GainsYear{
+					Year:      y.Year,
+					ShortTerm: &Term{Proceeds: y.ShortTerm.Proceeds, Cost: y.ShortTerm.Cost, Gain: y.ShortTerm.Gain},
+					LongTerm:  &Term{Proceeds: y.LongTerm.Proceeds, Cost: y.LongTerm.Cost, Gain: y.LongTerm.Gain},
+				})
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	rpt := &reports.Holdings{Currency: data.Currency, From: from, AsOf: asOf, Method: data.Method, Selections: sel}
+	err = rpt.Build(ts, conv)
 	if err != nil {
 		switch err.(type) {
 		case *reports.Oversold:
@@ -766,11 +1968,80 @@ func (env *Env) postReportAsync(w http.ResponseWriter, r *http.Request) {
 	// add the items
 	for _, i := range rpt.Items {
 		resp.Items = append(resp.Items, &Item{
-			Asset:  i.Asset,
-			Amount: i.Amount,
-			ACB:    i.ACB,
-			Value:  i.Value,
-			Gain:   i.Gain,
+			Asset:   i.Asset,
+			Amount:  i.Amount,
+			ACB:     i.ACB,
+			Value:   i.Value,
+			Gain:    i.Gain,
+			Percent: i.Percent,
+		})
+	}
+
+	// the lots sold from and left
+	if data.Method != "" && data.Method != reports.AverageCost {
+		for _, d := range rpt.Dispositions {
+			term := "short"
+			if d.LongTerm {
+				term = "long"
+			}
+			resp.Sales = append(resp.Sales, &Sale{
+				SaleID:   d.SaleID,
+				LotID:    d.LotID,
+				Acquired: d.Acquired,
+				Term:     term,
+				Date:     d.Date,
+				Asset:    d.Asset,
+				Amount:   d.Amount,
+				Proceeds: d.Proceeds,
+				ACB:      d.ACB,
+				Gain:     d.Gain,
+			})
+		}
+		for _, l := range rpt.Lots {
+			resp.Lots = append(resp.Lots, &Lot{
+				LotID:  l.TradeID,
+				Date:   l.Date,
+				Asset:  l.Asset,
+				Amount: l.Amount,
+				Cost:   l.Cost,
+			})
+		}
+		resp.Selections = sel
+	}
+
+	resp.Realized = rpt.Realized
+
+	// flag the sales with a loss denied
+	for _, d := range rpt.Dispositions {
+		if d.Superficial.IsPositive() {
+			resp.Superficial = append(resp.Superficial, &Superficial{
+				Date:   d.Date,
+				Asset:  d.Asset,
+				Amount: d.Amount,
+				Denied: d.Superficial,
+			})
+		}
+	}
+
+	// coins sent or received that the holdings can't account for
+	resp.Unmatched = unmatched(ts, trs, asOf)
+
+	// break down the amounts by account
+	as, err := env.db.GetAccounts(s.UserID)
+	if err != nil {
+		http.Error(w, "Error getting user accounts", http.StatusInternalServerError)
+		return
+	}
+	names := make(map[uint]string)
+	for _, a := range as {
+		names[a.ID] = a.Name
+	}
+	rpt.BuildAccounts(ts, trs)
+	for _, i := range rpt.Accounts {
+		resp.Accounts = append(resp.Accounts, &AccountItem{
+			Account: names[i.AccountID],
+			Asset:   i.Asset,
+			Amount:  i.Amount,
 		})
 	}
 
@@ -7,6 +7,7 @@ import (
 	"path"
 	"time"
 
+	"github.com/mathieugilbert/cryptotax/cmd/exchange"
 	"github.com/mathieugilbert/cryptotax/cmd/reports"
 	"github.com/mathieugilbert/cryptotax/models"
 	"github.com/shopspring/decimal"
@@ -129,6 +130,50 @@ func (env *Env) validToken(r *http.Request, t string) bool {
 	return t == s.CSRFToken
 }
 
+// resolveRates gets the rates a report on the trades needs from the exchange,
+// and its cache, with the rates to value holdings at a date when set
+func resolveRates(ts []*models.Trade, base string, value time.Time, g exchange.Granularity) ([]*reports.RateRequest, error) {
+	rrs, err := reports.Analyze(ts, base)
+	if err != nil {
+		return nil, err
+	}
+	if !value.IsZero() {
+		if v := reports.Valuation(ts, base, value); v != nil {
+			rrs = append(rrs, v)
+		}
+	}
+
+	for _, rr := range rrs {
+		for _, r := range rr.Rates {
+			rate, _, err := exchange.FetchPath(base, r.Currency, time.Unix(rr.Timestamp, 0), g)
+			if err != nil {
+				return nil, err
+			}
+			r.Rate = rate.String()
+		}
+	}
+	return rrs, nil
+}
+
+// granularity of the rates a report asked for, the day's average by default
+func granularity(s string) (exchange.Granularity, bool) {
+	if s == "" {
+		return exchange.DayAverage, true
+	}
+	g := exchange.Granularity(s)
+	return g, exchange.ValidGranularity(g)
+}
+
+// reportConverter values at the user's own trades within the configured
+// window, falling back to the rates
+func reportConverter(ts []*models.Trade, rates []*reports.RateRequest) reports.Converter {
+	c := rateConverter(rates)
+	if w, _ := Config.ObservedWindow(); w > 0 {
+		return reports.Observed(ts, w, c)
+	}
+	return c
+}
+
 func rateConverter(rates []*reports.RateRequest) reports.Converter {
 	return reports.Converter{
 		Convert: func(amount decimal.Decimal, from, to string, date time.Time) decimal.Decimal {
@@ -139,7 +184,7 @@ func rateConverter(rates []*reports.RateRequest) reports.Converter {
 			for _, rs := range rates {
 				if rs.Timestamp == date.Unix() {
 					for _, r := range rs.Rates {
-						if r.Currency == to {
+						if r.Currency == from {
 							rate, err := decimal.NewFromString(r.Rate)
 							if err != nil || rate.IsZero() {
 								return decimal.NewFromFloat(0)
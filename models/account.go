@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Account is where assets are held: an exchange or a wallet
+type Account struct {
+	ID        uint      `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time `gorm:"not null" json:"createdAt"`
+	Name      string    `gorm:"not null" json:"name"`
+	Kind      string    `gorm:"not null" json:"kind"` // exchange or wallet
+	UserID    uint      `gorm:"not null" json:"userId"`
+}
+
+// ValidAccountKind checks the kind of account
+func ValidAccountKind(k string) bool {
+	return k == "exchange" || k == "wallet"
+}
+
+// SaveAccount stores the account and returns it
+func (db *DB) SaveAccount(a *Account) (*Account, error) {
+	dbc := db.Create(a)
+	if dbc.Error != nil {
+		return nil, dbc.Error
+	}
+	return dbc.Value.(*Account), nil
+}
+
+// GetAccount returns the account by id and user id
+func (db *DB) GetAccount(id uint, uid uint) (*Account, error) {
+	a := &Account{}
+	if err := db.Where(&Account{ID: id, UserID: uid}).First(a).Error; err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetAccounts returns a user's accounts
+func (db *DB) GetAccounts(uid uint) ([]*Account, error) {
+	var as []*Account
+	err := db.Where(&Account{UserID: uid}).Order("name asc").Find(&as).Error
+	return as, err
+}
+
+// AccountNamed returns the user's account by name, created with the kind if missing
+func (db *DB) AccountNamed(name, kind string, uid uint) (*Account, error) {
+	a := &Account{}
+	err := db.Where(&Account{Name: name, UserID: uid}).FirstOrCreate(a, &Account{Name: name, Kind: kind, UserID: uid}).Error
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// DeleteAccount deletes the account by id and user id, if nothing is held in it
+func (db *DB) DeleteAccount(id uint, uid uint) error {
+	q := db.Exec("DELETE FROM accounts WHERE id = ? AND user_id = ?", id, uid)
+	if deleted := q.RowsAffected == 1; !deleted {
+		return errors.New("unable to delete account")
+	}
+	return q.Error
+}
+
+// MoveFile attaches the file and its trades to another of the user's accounts
+func (db *DB) MoveFile(fid uint, aid uint, uid uint) error {
+	if _, err := db.GetAccount(aid, uid); err != nil {
+		return err
+	}
+	q := db.Exec("UPDATE files SET account_id = ? WHERE id = ? AND user_id = ?", aid, fid, uid)
+	if q.Error != nil {
+		return q.Error
+	}
+	if moved := q.RowsAffected == 1; !moved {
+		return errors.New("unable to move file")
+	}
+	return db.Exec("UPDATE trades SET account_id = ? WHERE file_id = ? AND user_id = ?", aid, fid, uid).Error
+}
@@ -3,6 +3,8 @@ package models
 // https://www.alexedwards.net/blog/organising-database-access
 
 import (
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
@@ -19,12 +21,33 @@ type Datastore interface {
 	Authenticate(string, string) (*User, error)
 	VerifyEmail(string) bool
 	GetFiles(uint) ([]*File, error)
+	GetAccountFiles(uint, uint, string) ([]*File, error)
 	DeleteFile(uint, uint) error
 	GetFileTrades(uint, uint) ([]*Trade, error)
 	GetManualTrades(uint) ([]*Trade, error)
 	SaveTrade(*Trade) (*Trade, error)
 	DeleteTrade(uint, uint) error
 	GetUserTrades(uint) ([]*Trade, error)
+	GetTradesByFingerprint(uint, []string) ([]*Trade, error)
+	MergeTrade(uint, uint, *Trade) error
+	SaveProfile(*Profile) (*Profile, error)
+	GetProfile(uint, uint) (*Profile, error)
+	GetProfiles(uint) ([]*Profile, error)
+	DeleteProfile(uint, uint) error
+	SaveAccount(*Account) (*Account, error)
+	GetAccount(uint, uint) (*Account, error)
+	GetAccounts(uint) ([]*Account, error)
+	AccountNamed(string, string, uint) (*Account, error)
+	DeleteAccount(uint, uint) error
+	MoveFile(uint, uint, uint) error
+	SaveTransfer(*Transfer) (*Transfer, error)
+	GetTransfers(uint) ([]*Transfer, error)
+	DeleteTransfer(uint, uint) error
+	SaveLotSelection(*LotSelection) (*LotSelection, error)
+	GetLotSelections(uint) ([]*LotSelection, error)
+	DeleteLotSelection(uint, uint) error
+	GetPrice(string, string, time.Time) (*Price, error)
+	SavePrices([]*Price) error
 }
 
 // DB wraps gorm.DB
@@ -12,6 +12,7 @@ type File struct {
 	Name      string    `gorm:"not null"`
 	Source    string    `gorm:"not null"`
 	Bytes     []byte    `gorm:"type:bytea;not null"`
+	AccountID uint      `gorm:"not null"`
 	UserID    uint      `gorm:"not null"`
 }
 
@@ -34,7 +35,14 @@ func (db *DB) GetFile(id uint) (*File, error) {
 // GetFiles returns a user's files
 func (db *DB) GetFiles(uid uint) ([]*File, error) {
 	var fs []*File
-	err := db.Select("id, created_at, name, source").Where(&File{UserID: uid}).Order("created_at asc").Find(&fs).Error
+	err := db.Select("id, created_at, name, source, account_id").Where(&File{UserID: uid}).Order("created_at asc").Find(&fs).Error
+	return fs, err
+}
+
+// GetAccountFiles returns the files of an account from the source, with their bytes
+func (db *DB) GetAccountFiles(aid uint, uid uint, source string) ([]*File, error) {
+	var fs []*File
+	err := db.Where(&File{AccountID: aid, UserID: uid, Source: source}).Order("created_at asc").Find(&fs).Error
 	return fs, err
 }
 
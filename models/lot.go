@@ -0,0 +1,45 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// LotSelection picks the lot a sale is taken from, for specific
+// identification. Lots are the trades that acquired the asset.
+type LotSelection struct {
+	ID        uint            `gorm:"primary_key" json:"id"`
+	CreatedAt time.Time       `gorm:"not null" json:"createdAt"`
+	SaleID    uint            `gorm:"not null" json:"saleId"`
+	LotID     uint            `gorm:"not null" json:"lotId"`
+	Asset     string          `gorm:"not null" json:"asset"`
+	Amount    decimal.Decimal `gorm:"type:decimal;not null" json:"amount"`
+	UserID    uint            `gorm:"not null" json:"userId"`
+}
+
+// SaveLotSelection stores the selection and returns it
+func (db *DB) SaveLotSelection(s *LotSelection) (*LotSelection, error) {
+	dbc := db.Create(s)
+	if dbc.Error != nil {
+		return nil, dbc.Error
+	}
+	return dbc.Value.(*LotSelection), nil
+}
+
+// GetLotSelections returns a user's lot selections
+func (db *DB) GetLotSelections(uid uint) ([]*LotSelection, error) {
+	var ss []*LotSelection
+	err := db.Where(&LotSelection{UserID: uid}).Order("id asc").Find(&ss).Error
+	return ss, err
+}
+
+// DeleteLotSelection deletes the selection by id and user id
+func (db *DB) DeleteLotSelection(id uint, uid uint) error {
+	q := db.Exec("DELETE FROM lot_selections WHERE id = ? AND user_id = ?", id, uid)
+	if deleted := q.RowsAffected == 1; !deleted {
+		return errors.New("unable to delete lot selection")
+	}
+	return q.Error
+}
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Price is the rate of a currency in another on a day. Historical rates
+// don't change, they're kept for good.
+type Price struct {
+	ID        uint            `gorm:"primary_key"`
+	From      string          `gorm:"not null"`
+	To        string          `gorm:"not null"`
+	Date      time.Time       `gorm:"not null"`
+	Rate      decimal.Decimal `gorm:"type:decimal;not null"`
+	Source    string          `gorm:"not null"`
+	FetchedAt time.Time       `gorm:"not null"`
+}
+
+// Day is the start of the date's day in UTC, the date prices are kept at
+func Day(date time.Time) time.Time {
+	return date.UTC().Truncate(24 * time.Hour)
+}
+
+// GetPrice returns the price of from in to on the day of the date
+func (db *DB) GetPrice(from, to string, date time.Time) (*Price, error) {
+	p := &Price{}
+	err := db.Where(&Price{From: from, To: to, Date: Day(date)}).First(p).Error
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SavePrices stores the prices, replacing those already kept for their day
+func (db *DB) SavePrices(ps []*Price) error {
+	tx := db.Begin()
+	for _, p := range ps {
+		q := tx.Exec(`INSERT INTO prices ("from", "to", date, rate, source, fetched_at) VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT ("from", "to", date) DO UPDATE SET rate = EXCLUDED.rate, source = EXCLUDED.source, fetched_at = EXCLUDED.fetched_at`,
+			p.From, p.To, Day(p.Date), p.Rate, p.Source, p.FetchedAt)
+		if q.Error != nil {
+			tx.Rollback()
+			return q.Error
+		}
+	}
+	return tx.Commit().Error
+}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Profile maps the columns of an arbitrary trade file.
+// Column values are the header names in the file.
+type Profile struct {
+	ID                 uint      `gorm:"primary_key" json:"id"`
+	CreatedAt          time.Time `gorm:"not null" json:"createdAt"`
+	Name               string    `gorm:"not null" json:"name"`
+	DateColumn         string    `gorm:"not null" json:"dateColumn"`
+	DateLayout         string    `json:"dateLayout"`
+	ActionColumn       string    `gorm:"not null" json:"actionColumn"`
+	BuyWords           string    `gorm:"not null" json:"buyWords"`
+	SellWords          string    `gorm:"not null" json:"sellWords"`
+	AmountColumn       string    `gorm:"not null" json:"amountColumn"`
+	CurrencyColumn     string    `gorm:"not null" json:"currencyColumn"`
+	BaseAmountColumn   string    `gorm:"not null" json:"baseAmountColumn"`
+	BaseCurrencyColumn string    `gorm:"not null" json:"baseCurrencyColumn"`
+	FeeAmountColumn    string    `json:"feeAmountColumn"`
+	FeeCurrencyColumn  string    `json:"feeCurrencyColumn"`
+	UserID             uint      `gorm:"not null" json:"userId"`
+}
+
+// Buys returns the lower case words meaning a buy
+func (p *Profile) Buys() []string {
+	return words(p.BuyWords)
+}
+
+// Sells returns the lower case words meaning a sell
+func (p *Profile) Sells() []string {
+	return words(p.SellWords)
+}
+
+// split comma separated words
+func words(s string) (ws []string) {
+	for _, w := range strings.Split(s, ",") {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			ws = append(ws, w)
+		}
+	}
+	return
+}
+
+// SaveProfile stores the profile and returns it
+func (db *DB) SaveProfile(p *Profile) (*Profile, error) {
+	dbc := db.Create(p)
+	if dbc.Error != nil {
+		return nil, dbc.Error
+	}
+	return dbc.Value.(*Profile), nil
+}
+
+// GetProfile returns the profile by id and user id
+func (db *DB) GetProfile(id uint, uid uint) (*Profile, error) {
+	p := &Profile{}
+	if err := db.Where(&Profile{ID: id, UserID: uid}).First(p).Error; err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetProfiles returns a user's profiles
+func (db *DB) GetProfiles(uid uint) ([]*Profile, error) {
+	var ps []*Profile
+	err := db.Where(&Profile{UserID: uid}).Order("name asc").Find(&ps).Error
+	return ps, err
+}
+
+// DeleteProfile deletes the profile by id and user id
+func (db *DB) DeleteProfile(id uint, uid uint) error {
+	q := db.Exec("DELETE FROM profiles WHERE id = ? AND user_id = ?", id, uid)
+	if deleted := q.RowsAffected == 1; !deleted {
+		return errors.New("unable to delete profile")
+	}
+	return q.Error
+}
@@ -1,8 +1,11 @@
 package models
 
 import (
+	"crypto/sha1"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -21,7 +24,35 @@ type Trade struct {
 	FeeAmount    decimal.Decimal `gorm:"type:decimal;not null" json:"feeAmount"`
 	FeeCurrency  string          `gorm:"not null" json:"feeCurrency"`
 	FileID       uint            `json:"fileId"`
+	AccountID    uint            `gorm:"not null" json:"accountId"`
 	UserID       uint            `gorm:"not null" json:"userId"`
+	Fingerprint  string          `json:"-"`
+}
+
+// IsIncome is an acquisition taxed as income at its fair market value
+func IsIncome(action string) bool {
+	switch action {
+	case "STAKING", "MINING", "AIRDROP", "INTEREST", "REWARD":
+		return true
+	}
+	return false
+}
+
+// Fingerprint identifies a trade across files of the same source, so
+// overlapping exports can be recognized. Fees are left out, they are
+// sometimes rounded differently from one export to the next.
+func Fingerprint(t *Trade, source string) string {
+	v := strings.Join([]string{
+		strings.ToUpper(source),
+		t.Date.UTC().Format(time.RFC3339),
+		t.Action,
+		t.Amount.String(),
+		t.Currency,
+		t.BaseAmount.String(),
+		t.BaseCurrency,
+	}, "|")
+	h := sha1.Sum([]byte(v))
+	return hex.EncodeToString(h[:])
 }
 
 // SaveTrade stores the Trade and returns its ID
@@ -29,8 +60,8 @@ func (db *DB) SaveTrade(t *Trade) (*Trade, error) {
 	// handle nullable foreign key file_id
 	fid := sql.NullInt64{Int64: int64(t.FileID), Valid: t.FileID > 0}
 
-	q := "INSERT into trades (created_at, date, action, currency, amount, base_currency, base_amount, fee_amount, fee_currency, file_id, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id"
-	c := db.Raw(q, time.Now(), t.Date, t.Action, t.Currency, t.Amount, t.BaseCurrency, t.BaseAmount, t.FeeAmount, t.FeeCurrency, fid, t.UserID)
+	q := "INSERT into trades (created_at, date, action, currency, amount, base_currency, base_amount, fee_amount, fee_currency, file_id, account_id, user_id, fingerprint) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id"
+	c := db.Raw(q, time.Now(), t.Date, t.Action, t.Currency, t.Amount, t.BaseCurrency, t.BaseAmount, t.FeeAmount, t.FeeCurrency, fid, t.AccountID, t.UserID, t.Fingerprint)
 	if c.Error != nil {
 		return nil, c.Error
 	}
@@ -82,3 +113,23 @@ func (db *DB) GetUserTrades(id uint) (ts []*Trade, err error) {
 	err = db.Where(&Trade{UserID: id}).Order("date asc").Find(&ts).Error
 	return ts, err
 }
+
+// GetTradesByFingerprint returns the user's trades matching any of the fingerprints
+func (db *DB) GetTradesByFingerprint(uid uint, fps []string) (ts []*Trade, err error) {
+	if len(fps) == 0 {
+		return
+	}
+	err = db.Where("user_id = ? AND fingerprint IN (?)", uid, fps).Order("date asc").Find(&ts).Error
+	return
+}
+
+// MergeTrade moves an existing trade of the user to another file,
+// taking the fee of the duplicate it is merged with
+func (db *DB) MergeTrade(id uint, uid uint, dup *Trade) error {
+	q := db.Exec("UPDATE trades SET fee_amount = ?, fee_currency = ?, file_id = ?, account_id = ? WHERE id = ? AND user_id = ?",
+		dup.FeeAmount, dup.FeeCurrency, dup.FileID, dup.AccountID, id, uid)
+	if updated := q.RowsAffected == 1; !updated {
+		return errors.New("unable to merge trade")
+	}
+	return q.Error
+}
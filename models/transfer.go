@@ -0,0 +1,62 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Transfer moves an asset between two of the user's accounts.
+// Only the network fee is a disposition, the rest keeps its cost.
+type Transfer struct {
+	ID            uint            `gorm:"primary_key" json:"id"`
+	CreatedAt     time.Time       `gorm:"not null" json:"createdAt"`
+	Date          time.Time       `gorm:"not null" json:"date"`
+	Asset         string          `gorm:"not null" json:"asset"`
+	Amount        decimal.Decimal `gorm:"type:decimal;not null" json:"amount"` // received
+	FromAccountID uint            `gorm:"not null" json:"fromAccountId"`
+	ToAccountID   uint            `gorm:"not null" json:"toAccountId"`
+	FeeAmount     decimal.Decimal `gorm:"type:decimal;not null" json:"feeAmount"` // network fee, in asset
+	TxHash        string          `json:"txHash"`
+	WithdrawalID  uint            `json:"withdrawalId"` // SEND trade, if imported
+	DepositID     uint            `json:"depositId"`    // RECEIVE trade, if imported
+	UserID        uint            `gorm:"not null" json:"userId"`
+}
+
+// SaveTransfer stores the Transfer and returns it
+func (db *DB) SaveTransfer(t *Transfer) (*Transfer, error) {
+	// handle nullable foreign keys to the matched trades
+	wid := sql.NullInt64{Int64: int64(t.WithdrawalID), Valid: t.WithdrawalID > 0}
+	did := sql.NullInt64{Int64: int64(t.DepositID), Valid: t.DepositID > 0}
+
+	q := "INSERT into transfers (created_at, date, asset, amount, from_account_id, to_account_id, fee_amount, tx_hash, withdrawal_id, deposit_id, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id"
+	c := db.Raw(q, time.Now(), t.Date, t.Asset, t.Amount, t.FromAccountID, t.ToAccountID, t.FeeAmount, t.TxHash, wid, did, t.UserID)
+	if c.Error != nil {
+		return nil, c.Error
+	}
+
+	var id uint
+	if err := c.Row().Scan(&id); err != nil {
+		return nil, err
+	}
+
+	t.ID = id
+	return t, nil
+}
+
+// GetTransfers returns a user's transfers
+func (db *DB) GetTransfers(uid uint) (ts []*Transfer, err error) {
+	err = db.Raw("SELECT id, created_at, date, asset, amount, from_account_id, to_account_id, fee_amount, tx_hash, COALESCE(withdrawal_id, 0) AS withdrawal_id, COALESCE(deposit_id, 0) AS deposit_id, user_id FROM transfers WHERE user_id = ? ORDER BY date asc", uid).Scan(&ts).Error
+	return
+}
+
+// DeleteTransfer deletes the transfer by id and user id, its trades are left alone
+func (db *DB) DeleteTransfer(id uint, uid uint) error {
+	q := db.Exec("DELETE FROM transfers WHERE id = ? AND user_id = ?", id, uid)
+	if deleted := q.RowsAffected == 1; !deleted {
+		return errors.New("unable to delete transfer")
+	}
+	return q.Error
+}
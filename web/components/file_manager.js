@@ -3,6 +3,7 @@ $(document).ready(function() {
         data() {
             return {
                 files: app.files,
+                accounts: app.accounts,
                 trades: app.trades
             }
         },
@@ -16,9 +17,16 @@ $(document).ready(function() {
             upload: function(e, file) {
                 var s = e.currentTarget;
                 if (s.selectedIndex > 0) {
-                    uploadFile(file.id, s.value);
+                    var profile = $(s.options[s.selectedIndex]).data("profile") || 0;
+                    uploadFile(file.id, s.value, profile);
                 }
             },
+            move: function(e, file) {
+                moveFile(file);
+            },
+            resolve: function(e, file, choice) {
+                uploadFile(file.id, file.exchange, file.profileId, choice);
+            },
             confirmDelete: function(e, file) {
                 var fi = app.files.findIndex(f => f.id === file.id);
                 if (fi > -1) {
@@ -103,6 +111,10 @@ $(document).ready(function() {
                 "bytes": "",
                 "state": success ? "added" : "addfailed",
                 "exchange": "",
+                "candidates": [],
+                "skipped": [],
+                "duplicates": [],
+                "failed": [],
                 "message": message,
                 "success": success
             };
@@ -118,6 +130,8 @@ $(document).ready(function() {
                 f.bytes = btoa(bytes);
                 // store locally
                 app.files.push(f);
+                // try to recognize the exchange from the file
+                uploadFile(f.id, "Auto");
             }
             var fr = new FileReader();
             fr.onload = function() {
@@ -140,7 +154,7 @@ $(document).ready(function() {
     });
 });
 
-function uploadFile(id, exchange) {
+function uploadFile(id, exchange, profileId, duplicates) {
     // get the index of file in app.files
     var fi = app.files.findIndex(f => f.id === id);
     var file = app.files[fi];
@@ -151,10 +165,13 @@ function uploadFile(id, exchange) {
     file.state = "uploading";
     file.success = true;
     file.message = "";
+    file.profileId = profileId || 0;
 
     var data = JSON.stringify({
         fileBytes: file.bytes,
         exchange: exchange,
+        profileId: profileId || 0,
+        duplicates: duplicates || "",
         fileName: file.name,
         CSRFToken: $('input[name="csrf_token"]').val()
     });
@@ -170,8 +187,17 @@ function uploadFile(id, exchange) {
     }).done(function(data) {
         // update from server
         file.id = data.fileId;
+        file.accountId = data.accountId;
+        if (data.success && app.accounts.find(a => a.id === data.accountId) === undefined) {
+            // created for the exchange
+            app.accounts.push({"id": data.accountId, "name": data.exchange});
+        }
         file.date = data.date;
         file.exchange = data.exchange;
+        file.candidates = data.candidates || [];
+        file.skipped = data.skipped || [];
+        file.failed = data.failed || [];
+        file.duplicates = data.duplicates || [];
         file.message = data.message;
         file.success = data.success;
         file.state = data.success ? "uploaded" : "added";
@@ -182,6 +208,106 @@ function uploadFile(id, exchange) {
     });
 }
 
+function moveFile(file) {
+    var url = '/file/account?id=' + file.id + '&account=' + file.accountId;
+    url += '&csrf_token=' + $('input[name="csrf_token"]').val();
+
+    $.ajax({
+        url: url,
+        type: 'POST',
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).fail(function(e) {
+        file.success = false;
+        file.message = "Failed to move file.";
+    });
+}
+
+function saveAccount(form) {
+    var data = JSON.stringify({
+        name: $(form).find("input[name='name']").val(),
+        kind: $(form).find("select[name='kind']").val(),
+        CSRFToken: $('input[name="csrf_token"]').val()
+    });
+
+    $.ajax({
+        url: '/account',
+        type: 'POST',
+        data: data,
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        window.location.reload();
+    }).fail(function(xhr) {
+        $(form).find(".help.is-danger").text(xhr.responseText);
+    });
+}
+
+function deleteAccount(id) {
+    var url = '/account?id=' + id + '&csrf_token=' + $('input[name="csrf_token"]').val();
+
+    $.ajax({
+        url: url,
+        type: 'DELETE',
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        window.location.reload();
+    }).fail(function(xhr) {
+        $("form#account").find(".help.is-danger").text(xhr.responseText);
+    });
+}
+
+function saveProfile(form) {
+    var profile = {};
+    $(form).find("input[data-field]").each(function() {
+        profile[$(this).data("field")] = $(this).val();
+    });
+
+    var data = JSON.stringify({
+        profile: profile,
+        CSRFToken: $('input[name="csrf_token"]').val()
+    });
+
+    $.ajax({
+        url: '/profile',
+        type: 'POST',
+        data: data,
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        // reload to add the profile to the exchange lists
+        window.location.reload();
+    }).fail(function(xhr) {
+        $(form).find(".help.is-danger").text(xhr.responseText);
+    });
+}
+
+function deleteProfile(id) {
+    var url = '/profile?id=' + id + '&csrf_token=' + $('input[name="csrf_token"]').val();
+
+    $.ajax({
+        url: url,
+        type: 'DELETE',
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        window.location.reload();
+    }).fail(function(xhr) {
+        $("form#profile").find(".help.is-danger").text("Failed to delete profile.");
+    });
+}
+
 function deleteFile(file, index) {
     if (file.state !== "uploaded") {
         return;
@@ -3,7 +3,19 @@ Vue.component('report-viewer', {
         return {
             report: app.report,
             items: app.reportItems,
-            rates: app.rates
+            accounts: app.reportAccounts,
+            realized: app.reportRealized,
+            asOfDate: "",
+            income: app.reportIncome,
+            superficial: app.reportSuperficial,
+            unmatched: app.reportUnmatched,
+            sales: app.reportSales,
+            gains: app.reportGains,
+            acb: app.reportACB,
+            schedule3: app.reportSchedule3,
+            lots: app.reportLots,
+            selections: app.lotSelections,
+            newSelection: app.newSelection
         }
     },
     methods: {
@@ -12,6 +24,9 @@ Vue.component('report-viewer', {
             case "CAD":
                 this.report.locale = "en-CA";
                 break;
+            case "USD":
+                this.report.locale = "en-US";
+                break;
             }
         },
         currency: function(val) {
@@ -29,6 +44,35 @@ Vue.component('report-viewer', {
             });
 
             return formatter.format(val);
+        },
+        setAsOfDate: function(e) {
+            if (this.asOfDate !== "") {
+                this.report.asOf = this.asOfDate;
+            }
+        },
+        saleLabel: function(s) {
+            return s.date.substring(0, 10) + " " + s.asset + " (#" + s.saleId + ")";
+        },
+        addSelection: function(e) {
+            saveSelection(app.newSelection);
+        },
+        deleteSelection: function(e, s) {
+            deleteSelection(s.id);
+        },
+        download8949: function(e, year) {
+            downloadExport('/report/8949', 'cryptotax-8949-' + year + '.csv', {
+                currency: this.report.currency,
+                method: this.report.method,
+                rates: this.report.rates,
+                year: year
+            });
+        },
+        downloadSchedule3: function(e, year) {
+            downloadExport('/report/schedule3', 'cryptotax-schedule3-' + year + '.csv', {
+                currency: this.report.currency,
+                rates: this.report.rates,
+                year: year
+            });
         }
     },
     watch: {
@@ -40,6 +84,54 @@ Vue.component('report-viewer', {
             },
             deep: true
         }
+    },
+    computed: {
+        // tax years to report as of, latest first
+        years: function() {
+            var ys = [];
+            for (var y = new Date().getFullYear() - 1; y >= 2013; y--) {
+                ys.push(y);
+            }
+            return ys;
+        },
+        asOfYear: function() {
+            if (this.report.asOf === "Today") {
+                return new Date().getFullYear();
+            }
+            return this.report.asOf.substring(0, 4);
+        },
+        // one entry per sale
+        saleChoices: function() {
+            var seen = {};
+            return this.sales.filter(s => {
+                var key = s.saleId + s.asset;
+                if (s.saleId === 0 || seen[key]) {
+                    return false;
+                }
+                seen[key] = true;
+                return true;
+            });
+        },
+        // lots of the asset sold, acquired before the sale
+        lotChoices: function() {
+            var sale = this.sales.find(s => s.saleId === Number(this.newSelection.saleId));
+            if (sale === undefined) {
+                return [];
+            }
+            var ids = {};
+            this.sales.concat(this.lots).forEach(l => {
+                var acquired = l.acquired || l.date;
+                if (l.asset === sale.asset && l.lotId > 0 && acquired <= sale.date) {
+                    ids[l.lotId] = acquired;
+                }
+            });
+            return Object.keys(ids).map(id => {
+                return { id: Number(id), date: ids[id].substring(0, 10) };
+            });
+        },
+        incomeTotal: function() {
+            return this.income.reduce((sum, i) => sum + Number(i.value), 0);
+        }
     }
 });
 
@@ -51,108 +143,168 @@ new Vue({
 async function loadReport(report) {
     app.loadingReport = true;
     app.reportItems.splice(0, app.reportItems.length);
+    app.reportAccounts.splice(0, app.reportAccounts.length);
+    app.reportIncome.splice(0, app.reportIncome.length);
+    app.reportSuperficial.splice(0, app.reportSuperficial.length);
+    app.reportUnmatched.splice(0, app.reportUnmatched.length);
+    app.reportSales.splice(0, app.reportSales.length);
+    app.reportGains.splice(0, app.reportGains.length);
+    app.reportACB.splice(0, app.reportACB.length);
+    app.reportSchedule3.splice(0, app.reportSchedule3.length);
+    app.reportLots.splice(0, app.reportLots.length);
+    app.lotSelections.splice(0, app.lotSelections.length);
     setError("");
 
-    var url = '/rateRequest?type=' + report.type + '&currency=' + report.currency + '&asof=' + report.asOf;
-    url += '&csrf_token=' + $('input[name="csrf_token"]').val();
+    var data = JSON.stringify({
+        type: report.type,
+        currency: report.currency,
+        asof: report.asOf,
+        method: report.method,
+        rates: report.rates,
+        CSRFToken: $('input[name="csrf_token"]').val()
+    });
 
     await $.ajax({
-        url: url,
-        type: 'GET',
+        url: "/report",
+        type: "POST",
+        data: data,
         cache: false,
         contentType: false,
         processData: false,
-        timeout: 21000
+        timeout: 60000
     }).done(function(data) {
+        app.loadingReport = false;
         if (data.error.length) {
-            setError(data.error);
+            setError(data.error); // TODO: make this work with vue data
             return;
         }
 
-        // [{"timestamp":1512266000,"rates":[{"currency":"ETH","rate":""},{"currency":"BTC","rate":""}]},{"timestamp":1515036507,"rates":[{"currency":"ETH","rate":""}]},
-        var items = data.items;
-        var promises = [];
-
-        for (var i = 0; i < items.length; i++) {
-            var req = items[i]; // {"timestamp":1512266000,"rates":[{"currency":"ETH","rate":""},{"currency":"BTC","rate":""}]}
-
-    		promises.push(new Promise(function(resolve, reject) {
-                getRates(app.report.currency, req.rates, req.timestamp, resolve, reject);
-            }));
+        if (report.type === "ACB") {
+            (data.acb || []).forEach(a => {
+                app.reportACB.push(a);
+            });
+            (data.unmatched || []).forEach(u => {
+                app.reportUnmatched.push(u);
+            });
+            app.reportRealized.total = data.realized;
+            return;
+        }
+        if (report.type === "Schedule3") {
+            (data.schedule3 || []).forEach(s => {
+                app.reportSchedule3.push(s);
+            });
+            return;
+        }
+        if (report.type === "Gains") {
+            (data.gains || []).forEach(g => {
+                app.reportGains.push(g);
+            });
+            return;
+        }
+        if (report.type === "Income") {
+            (data.income || []).forEach(i => {
+                app.reportIncome.push(i);
+            });
+            return;
         }
 
-        $(document).ajaxStop(function() {
-            if (app.loadingReport === true) {
-                getComputedReport(report, items);
-            }
-            app.loadingReport = false;
+        app.reportRealized.total = data.realized;
+        (data.accounts || []).forEach(a => {
+            app.reportAccounts.push(a);
         });
-
-        Promise.all(promises).then(function() {
-            console.log(JSON.stringify(items));
-            //app.loadingReport = true;
-    	}).catch(function(e) {
-            console.log(e);
+        (data.superficial || []).forEach(s => {
+            app.reportSuperficial.push(s);
+        });
+        (data.unmatched || []).forEach(u => {
+            app.reportUnmatched.push(u);
+        });
+        (data.sales || []).forEach(s => {
+            app.reportSales.push(s);
+        });
+        (data.lots || []).forEach(l => {
+            app.reportLots.push(l);
+        });
+        (data.selections || []).forEach(s => {
+            app.lotSelections.push(s);
+        });
+        (data.items || []).forEach(i => {
+            app.reportItems.push(i);
         });
     }).fail(function(xhr, status, error) {
-        console.log(error);
+        app.loadingReport = false;
+        setError(xhr.responseText || "Error loading report");
+    });
+}
+
+// downloadExport posts the report and saves the file returned
+function downloadExport(url, filename, params) {
+    params.CSRFToken = $('input[name="csrf_token"]').val();
+    var data = JSON.stringify(params);
+
+    $.ajax({
+        url: url,
+        type: 'POST',
+        data: data,
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 60000,
+        xhrFields: {
+            responseType: 'blob'
+        }
+    }).done(function(blob) {
+        var a = document.createElement('a');
+        a.href = URL.createObjectURL(blob);
+        a.download = filename;
+        document.body.appendChild(a);
+        a.click();
+        document.body.removeChild(a);
+    }).fail(function(xhr) {
+        setError("Couldn't export the report.");
     });
 }
 
-// TODO: make separate endpoint so this can be POSTed
-function getComputedReport(report, rates) {
+function saveSelection(sel) {
     var data = JSON.stringify({
-        type: report.type,
-        currency: report.currency,
-        asof: report.asOf,
-        rates: rates,
+        saleId: Number(sel.saleId),
+        lotId: Number(sel.lotId),
+        amount: sel.amount,
         CSRFToken: $('input[name="csrf_token"]').val()
     });
 
     $.ajax({
-        url: "/report",
-        type: "POST",
+        url: '/lot',
+        type: 'POST',
         data: data,
         cache: false,
         contentType: false,
         processData: false,
         timeout: 5000
     }).done(function(data) {
-        if (data.error.length) {
-            setError(data.error); // TODO: make this work with vue data
-            return;
-        }
-
-        var currs = data.items.map(item => {
-            return item.asset;
-        });
-
-        var p = new Promise(function(resolve, reject) {
-            getLiveRates(app.report.currency, currs, resolve, reject);
-        });
-
-        p.then((rates) => {
-            data.items.map(item => {
-                var amount = Number(item.amount);
-                var curr = item.asset;
-                var rate = rates.filter(r => r.currency === curr);
-                if (rate.length > 0) {
-                    var value = rate[0].rate === 0 ? 0 : amount / rate[0].rate;
-                    var gain = value / item.acb - 1;
+        sel.saleId = "";
+        sel.lotId = "";
+        sel.amount = "";
+        sel.error = "";
+        loadReport(app.report);
+    }).fail(function(xhr) {
+        sel.error = xhr.responseText || "Couldn't save lot.";
+    });
+}
 
-                    app.reportItems.push({
-                        asset: item.asset,
-                        amount: amount,
-                        acb: item.acb,
-                        value: value,
-                        gain: gain
-                    });
-                }
-            });
-        });
+function deleteSelection(id) {
+    var url = '/lot?id=' + id + '&csrf_token=' + $('input[name="csrf_token"]').val();
 
-    }).fail(function(xhr, status, error) {
-        console.log("Error loading report");
+    $.ajax({
+        url: url,
+        type: 'DELETE',
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        loadReport(app.report);
+    }).fail(function(e) {
+        console.log("Error deleting lot selection id: " + id);
     });
 }
 
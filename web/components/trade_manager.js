@@ -2,6 +2,7 @@ Vue.component('trade-manager', {
     data() {
         return {
             trades: app.trades,
+            accounts: app.accounts,
             newTrade: app.newTrade
         }
     },
@@ -33,6 +34,9 @@ Vue.component('trade-manager', {
             row.find(".confirm-button").toggleClass("hidden");
             row.find(".keep-button").toggleClass("hidden");
         },
+        accountName: function(id) {
+            return accountName(id);
+        },
         shortDate: function(date) {
             return formatDate(date);
         },
@@ -66,6 +70,7 @@ function resetTrade(t) {
 }
 
 function saveTrade(trade) {
+    trade.accountId = Number(trade.accountId);
     var data = JSON.stringify({
         trade: trade,
         CSRFToken: $('input[name="csrf_token"]').val()
@@ -110,8 +115,9 @@ function error(t) {
     if (t.date === undefined || t.date.length !== 10) {
         return "Invalid date.";
     }
-    if (t.action === undefined || (t.action !== "BUY" && t.action !== "SELL")) {
-        return "Must be BUY or SELL.";
+    var actions = ["BUY", "SELL", "STAKING", "MINING", "AIRDROP", "INTEREST"];
+    if (t.action === undefined || actions.indexOf(t.action) === -1) {
+        return "Must be BUY, SELL or income.";
     }
     if (t.amount === undefined || t.amount.length === 0) {
         return "Amount missing.";
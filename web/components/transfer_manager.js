@@ -0,0 +1,114 @@
+Vue.component('transfer-manager', {
+    data() {
+        return {
+            transfers: app.transfers,
+            accounts: app.accounts,
+            newTransfer: app.newTransfer,
+            message: ""
+        }
+    },
+    methods: {
+        addTransfer: function(e) {
+            saveTransfer(app.newTransfer);
+        },
+        match: function(e) {
+            var vm = this;
+            matchTransfers(function(n) {
+                vm.message = n + " transfers matched.";
+            });
+        },
+        deleteTransfer: function(e, transfer) {
+            deleteTransfer(transfer.id);
+            this.toggleDelete(e);
+        },
+        toggleDelete: function(e) {
+            var row = $(e.currentTarget).closest("tr")
+            row.find(".delete-button").toggleClass("hidden");
+            row.find(".confirm-button").toggleClass("hidden");
+            row.find(".keep-button").toggleClass("hidden");
+        },
+        accountName: function(id) {
+            return accountName(id);
+        },
+        shortDate: function(date) {
+            return formatDate(date);
+        },
+        longDate: function(date) {
+            return formatDateLong(date);
+        }
+    }
+});
+
+new Vue({
+    delimiters: ['${', '}'],
+    el: '#trm'
+});
+
+function resetTransfer(t) {
+    // update the attributes instead of overwriting, to remain vue-bound
+    var n = newTransfer();
+    Object.keys(n).forEach(function(key, i) {
+        t[key] = n[key];
+    });
+}
+
+function saveTransfer(transfer) {
+    var data = JSON.stringify({
+        transfer: transfer,
+        CSRFToken: $('input[name="csrf_token"]').val()
+    });
+
+    $.ajax({
+        url: '/transfer',
+        type: 'POST',
+        data: data,
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        app.transfers.push(data.transfer);
+        resetTransfer(app.newTransfer);
+    }).fail(function(xhr) {
+        app.newTransfer.error = xhr.responseText || "Couldn't save transfer.";
+    });
+}
+
+function matchTransfers(callback) {
+    var url = '/transfers/match?csrf_token=' + $('input[name="csrf_token"]').val();
+
+    $.ajax({
+        url: url,
+        type: 'POST',
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        var ts = data.transfers || [];
+        for (var i = 0; i < ts.length; i++) {
+            app.transfers.push(ts[i]);
+        }
+        callback(ts.length);
+    }).fail(function(e) {
+        console.log("Error matching transfers");
+    });
+}
+
+function deleteTransfer(tid) {
+    var tindex = app.transfers.findIndex(t => t.id === tid);
+    var url = '/transfer?id=' + tid + '&csrf_token=' + $('input[name="csrf_token"]').val();
+
+    $.ajax({
+        url: url,
+        type: 'DELETE',
+        cache: false,
+        contentType: false,
+        processData: false,
+        timeout: 5000
+    }).done(function(data) {
+        app.transfers.splice(tindex, 1);
+    }).fail(function(e) {
+        console.log("Error deleting transfer id: " + tid);
+    });
+}